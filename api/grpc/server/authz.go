@@ -0,0 +1,247 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/transports"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/hustcat/oci-torrent/api/grpc/types"
+)
+
+// AuthzRole lists the methods a client may call and the images it may
+// name in its requests. An empty image list allows every image, a role
+// with images only allows requests naming one of them.
+type AuthzRole struct {
+	Methods []string `json:"methods"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// AuthzPolicy maps client identities to roles. Identities are of the form
+// "cn:<certificate common name>" for TLS clients and "uid:<user id>" for
+// Unix socket clients. Identities, methods and images are patterns in
+// which "*" matches any sequence of characters.
+type AuthzPolicy struct {
+	Roles      map[string]AuthzRole `json:"roles"`
+	Identities map[string][]string  `json:"identities"`
+}
+
+// Authorizer checks every gRPC call against an AuthzPolicy
+type Authorizer struct {
+	identities []authzIdentity
+}
+
+// authzIdentity is an identity pattern of the policy with its roles, the
+// patterns compiled
+type authzIdentity struct {
+	pattern *regexp.Regexp
+	roles   []authzRole
+}
+
+type authzRole struct {
+	methods []*regexp.Regexp
+	images  []*regexp.Regexp
+}
+
+// NewAuthorizerFromFile loads the authorization policy at path
+func NewAuthorizerFromFile(path string) (*Authorizer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &AuthzPolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("Invalid authorization policy %s: %v", path, err)
+	}
+	return NewAuthorizer(policy)
+}
+
+// NewAuthorizer validates policy and returns an Authorizer enforcing it
+func NewAuthorizer(policy *AuthzPolicy) (*Authorizer, error) {
+	roles := map[string]authzRole{}
+	for name, role := range policy.Roles {
+		methods, err := compilePatterns(role.Methods)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid method of role %q: %v", name, err)
+		}
+		images, err := compilePatterns(role.Images)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid image of role %q: %v", name, err)
+		}
+		roles[name] = authzRole{methods: methods, images: images}
+	}
+
+	a := &Authorizer{}
+	for identity, names := range policy.Identities {
+		if !strings.HasPrefix(identity, "cn:") && !strings.HasPrefix(identity, "uid:") {
+			return nil, fmt.Errorf("Invalid identity %q, expected cn:NAME or uid:UID", identity)
+		}
+		pattern, err := compilePattern(identity)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid identity %q: %v", identity, err)
+		}
+		id := authzIdentity{pattern: pattern}
+		for _, name := range names {
+			role, ok := roles[name]
+			if !ok {
+				return nil, fmt.Errorf("Identity %q refers to unknown role %q", identity, name)
+			}
+			id.roles = append(id.roles, role)
+		}
+		a.identities = append(a.identities, id)
+	}
+	return a, nil
+}
+
+// UnaryInterceptor rejects calls that the policy does not allow
+func (a *Authorizer) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	identity, err := peerIdentity(ctx)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "%v", err)
+	}
+
+	method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+	image, err := requestImage(req)
+	if err != nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if !a.Allowed(identity, method, image) {
+		if image != "" {
+			return nil, grpc.Errorf(codes.PermissionDenied, "%s is not allowed to call %s on %s", identity, method, image)
+		}
+		return nil, grpc.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", identity, method)
+	}
+	return handler(ctx, req)
+}
+
+// Allowed returns true if one of the roles of identity allows calling
+// method on image. An empty image means the request names no image, such
+// as global rate limits or layers by ID, which roles limited to some
+// images are not allowed to call.
+func (a *Authorizer) Allowed(identity, method, image string) bool {
+	for _, id := range a.identities {
+		if !id.pattern.MatchString(identity) {
+			continue
+		}
+		for _, role := range id.roles {
+			if !matchAny(role.methods, method) {
+				continue
+			}
+			if len(role.images) == 0 || (image != "" && matchAny(role.images, image)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// peerIdentity returns the identity of the client calling the API
+func peerIdentity(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return "", fmt.Errorf("Unknown client identity")
+	}
+
+	switch info := p.AuthInfo.(type) {
+	case credentials.TLSInfo:
+		if len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+			return "", fmt.Errorf("Client certificate not verified")
+		}
+		return "cn:" + info.State.VerifiedChains[0][0].Subject.CommonName, nil
+	case UnixPeerInfo:
+		return "uid:" + strconv.FormatUint(uint64(info.Uid), 10), nil
+	}
+	return "", fmt.Errorf("Unsupported authentication type %s", p.AuthInfo.AuthType())
+}
+
+// requestImage returns the full repository name of the image a request
+// refers to, or "" if it does not refer to one.
+func requestImage(req interface{}) (string, error) {
+	var (
+		named reference.Named
+		err   error
+	)
+
+	switch r := req.(type) {
+	case *types.StartDownloadRequest:
+		ref, err := transports.ParseImageName(r.Source)
+		if err != nil {
+			return "", err
+		}
+		if named = ref.DockerReference(); named == nil {
+			return "", fmt.Errorf("Image %s has no docker reference", r.Source)
+		}
+	case *types.StopDownloadRequest:
+		named, err = reference.ParseNamed(r.Source)
+	case *types.StatusRequest:
+		named, err = reference.ParseNamed(r.Source)
+	case *types.GetSignaturesRequest:
+		named, err = reference.ParseNamed(r.Source)
+	case *types.SetRateLimitRequest:
+		if r.Source == "" {
+			return "", nil
+		}
+		named, err = reference.ParseNamed(r.Source)
+	case *types.GetRateLimitRequest:
+		if r.Source == "" {
+			return "", nil
+		}
+		named, err = reference.ParseNamed(r.Source)
+	case *types.GetMagnetsRequest:
+		if r.Source == "" {
+			return "", nil
+		}
+		named, err = reference.ParseNamed(r.Source)
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return named.FullName(), nil
+}
+
+func matchAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := compilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// compilePattern returns the regexp matching the strings of pattern, in
+// which "*" matches any sequence of characters
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("Empty pattern")
+	}
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/hustcat/oci-torrent/api/grpc/types"
+)
+
+func TestAuthorizerAllowed(t *testing.T) {
+	authz, err := NewAuthorizer(&AuthzPolicy{
+		Roles: map[string]AuthzRole{
+			"leecher": {
+				Methods: []string{"GetServerVersion", "GetTorrent", "GetSignatures", "Status"},
+			},
+			"operator": {
+				Methods: []string{"*"},
+				Images:  []string{"docker.io/library/*"},
+			},
+		},
+		Identities: map[string][]string{
+			"cn:node-*": {"leecher"},
+			"cn:ops":    {"leecher", "operator"},
+			"uid:0":     {"operator"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewAuthorizer: unexpected error: %s", err)
+	}
+
+	for _, test := range []struct {
+		identity string
+		method   string
+		image    string
+		allowed  bool
+	}{
+		{"cn:node-1", "GetTorrent", "", true},
+		{"cn:node-1", "Status", "docker.io/hustcat/busybox", true},
+		{"cn:node-1", "StartDownload", "docker.io/library/busybox", false},
+		{"cn:ops", "StartDownload", "docker.io/library/busybox", true},
+		{"cn:ops", "StartDownload", "docker.io/hustcat/busybox", false},
+		{"cn:ops", "GetTorrent", "", true},
+		{"cn:ops", "SetRateLimit", "", false},
+		{"uid:0", "GetMagnets", "", false},
+		{"uid:0", "StopDownload", "docker.io/library/busybox", true},
+		{"uid:1000", "GetServerVersion", "", false},
+		{"cn:other", "GetTorrent", "", false},
+	} {
+		if allowed := authz.Allowed(test.identity, test.method, test.image); allowed != test.allowed {
+			t.Errorf("Allowed(%s, %s, %s): expected=%v got=%v", test.identity, test.method, test.image, test.allowed, allowed)
+		}
+	}
+}
+
+func TestNewAuthorizerInvalid(t *testing.T) {
+	for _, policy := range []*AuthzPolicy{
+		{Identities: map[string][]string{"node": {"leecher"}}},
+		{Identities: map[string][]string{"cn:node": {"missing"}}},
+		{Roles: map[string]AuthzRole{"leecher": {Methods: []string{""}}}},
+		{Roles: map[string]AuthzRole{"operator": {Methods: []string{"*"}, Images: []string{""}}}},
+	} {
+		if _, err := NewAuthorizer(policy); err == nil {
+			t.Errorf("NewAuthorizer(%v): expected error", policy)
+		}
+	}
+}
+
+func TestRequestImage(t *testing.T) {
+	for _, test := range []struct {
+		req   interface{}
+		image string
+	}{
+		{&types.StartDownloadRequest{Source: "docker://busybox"}, "docker.io/library/busybox"},
+		{&types.StatusRequest{Source: "hustcat/busybox:v1"}, "docker.io/hustcat/busybox"},
+		{&types.GetTorrentRequest{Id: "abc"}, ""},
+	} {
+		image, err := requestImage(test.req)
+		if err != nil {
+			t.Errorf("requestImage(%v): unexpected error: %s", test.req, err)
+		}
+		if image != test.image {
+			t.Errorf("requestImage(%v): expected=%s got=%s", test.req, test.image, image)
+		}
+	}
+}
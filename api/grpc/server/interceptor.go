@@ -0,0 +1,39 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/hustcat/oci-torrent/metrics"
+)
+
+var requestDuration = metrics.NewHistogram("oci_torrent_grpc_request_duration_seconds",
+	"Latency of gRPC requests by method and status code.", metrics.DefBuckets, "method", "code")
+
+// MetricsInterceptor records the latency of every gRPC call
+func MetricsInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	method := info.FullMethod[strings.LastIndex(info.FullMethod, "/")+1:]
+	requestDuration.Observe(time.Since(start).Seconds(), method, grpc.Code(err).String())
+	return resp, err
+}
+
+// ChainUnaryInterceptors returns an interceptor calling interceptors in
+// order, the server accepts only one
+func ChainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
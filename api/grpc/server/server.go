@@ -42,3 +42,23 @@ func (s *apiServer) GetTorrent(ctx context.Context, r *types.GetTorrentRequest)
 func (s *apiServer) Status(ctx context.Context, r *types.StatusRequest) (*types.StatusResponse, error) {
 	return s.backend.Status(ctx, r)
 }
+
+func (s *apiServer) GetSignatures(ctx context.Context, r *types.GetSignaturesRequest) (*types.GetSignaturesResponse, error) {
+	return s.backend.GetSignatures(ctx, r)
+}
+
+func (s *apiServer) SetRateLimit(ctx context.Context, r *types.SetRateLimitRequest) (*types.SetRateLimitResponse, error) {
+	return s.backend.SetRateLimit(ctx, r)
+}
+
+func (s *apiServer) GetDaemonInfo(ctx context.Context, r *types.GetDaemonInfoRequest) (*types.GetDaemonInfoResponse, error) {
+	return s.backend.GetDaemonInfo(ctx, r)
+}
+
+func (s *apiServer) GetMagnets(ctx context.Context, r *types.GetMagnetsRequest) (*types.GetMagnetsResponse, error) {
+	return s.backend.GetMagnets(ctx, r)
+}
+
+func (s *apiServer) GetRateLimit(ctx context.Context, r *types.GetRateLimitRequest) (*types.GetRateLimitResponse, error) {
+	return s.backend.GetRateLimit(ctx, r)
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+)
+
+// UnixPeerInfo is the auth information of a Unix socket client
+type UnixPeerInfo struct {
+	Uid uint32
+}
+
+// AuthType returns the type of UnixPeerInfo as a string.
+func (UnixPeerInfo) AuthType() string {
+	return "unix"
+}
+
+// unixCreds identifies Unix socket clients by the credentials of the peer
+// process. It does not secure the connection.
+type unixCreds struct{}
+
+// NewUnixCredentials returns server credentials that attach the UID of the
+// peer process to the connections accepted on a Unix socket.
+func NewUnixCredentials() credentials.TransportCredentials {
+	return unixCreds{}
+}
+
+func (unixCreds) ClientHandshake(ctx context.Context, addr string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("unix credentials are server side only")
+}
+
+func (unixCreds) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, ok := rawConn.(*net.UnixConn)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s is not a unix socket connection", rawConn.RemoteAddr())
+	}
+	uid, err := getPeerUid(conn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Get peer credentials failed: %v", err)
+	}
+	return rawConn, UnixPeerInfo{Uid: uid}, nil
+}
+
+func (unixCreds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "unix",
+	}
+}
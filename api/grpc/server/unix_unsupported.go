@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+)
+
+// getPeerUid returns the UID of the process on the other end of conn
+func getPeerUid(conn *net.UnixConn) (uint32, error) {
+	return 0, fmt.Errorf("peer credentials are not supported on this platform")
+}
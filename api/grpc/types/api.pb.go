@@ -20,6 +20,17 @@ It has these top-level messages:
 	StatusRequest
 	LayerDownState
 	StatusResponse
+	GetSignaturesRequest
+	GetSignaturesResponse
+	SetRateLimitRequest
+	SetRateLimitResponse
+	GetRateLimitRequest
+	GetRateLimitResponse
+	GetDaemonInfoRequest
+	GetDaemonInfoResponse
+	GetMagnetsRequest
+	LayerMagnet
+	GetMagnetsResponse
 */
 package types
 
@@ -64,11 +75,13 @@ func (*GetServerVersionResponse) ProtoMessage()               {}
 func (*GetServerVersionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
 type StartDownloadRequest struct {
-	Source   string `protobuf:"bytes,1,opt,name=source" json:"source,omitempty"`
-	Stdout   string `protobuf:"bytes,2,opt,name=stdout" json:"stdout,omitempty"`
-	Stderr   string `protobuf:"bytes,3,opt,name=stderr" json:"stderr,omitempty"`
-	Username string `protobuf:"bytes,4,opt,name=username" json:"username,omitempty"`
-	Password string `protobuf:"bytes,5,opt,name=password" json:"password,omitempty"`
+	Source         string   `protobuf:"bytes,1,opt,name=source" json:"source,omitempty"`
+	Stdout         string   `protobuf:"bytes,2,opt,name=stdout" json:"stdout,omitempty"`
+	Stderr         string   `protobuf:"bytes,3,opt,name=stderr" json:"stderr,omitempty"`
+	Username       string   `protobuf:"bytes,4,opt,name=username" json:"username,omitempty"`
+	Password       string   `protobuf:"bytes,5,opt,name=password" json:"password,omitempty"`
+	LayerOrder     string   `protobuf:"bytes,6,opt,name=layerOrder" json:"layerOrder,omitempty"`
+	PriorityLayers []string `protobuf:"bytes,7,rep,name=priorityLayers" json:"priorityLayers,omitempty"`
 }
 
 func (m *StartDownloadRequest) Reset()                    { *m = StartDownloadRequest{} }
@@ -113,7 +126,9 @@ func (*GetTorrentRequest) ProtoMessage()               {}
 func (*GetTorrentRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
 type GetTorrentResponse struct {
-	Torrent []byte `protobuf:"bytes,1,opt,name=torrent,proto3" json:"torrent,omitempty"`
+	Torrent    []byte   `protobuf:"bytes,1,opt,name=torrent,proto3" json:"torrent,omitempty"`
+	ListenAddr string   `protobuf:"bytes,2,opt,name=listenAddr" json:"listenAddr,omitempty"`
+	Peers      []string `protobuf:"bytes,3,rep,name=peers" json:"peers,omitempty"`
 }
 
 func (m *GetTorrentResponse) Reset()                    { *m = GetTorrentResponse{} }
@@ -144,7 +159,10 @@ func (*LayerDownState) ProtoMessage()               {}
 func (*LayerDownState) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
 
 type StatusResponse struct {
-	LayerDownStates []*LayerDownState `protobuf:"bytes,1,rep,name=layerDownStates" json:"layerDownStates,omitempty"`
+	LayerDownStates   []*LayerDownState `protobuf:"bytes,1,rep,name=layerDownStates" json:"layerDownStates,omitempty"`
+	BandwidthWindow   string            `protobuf:"bytes,2,opt,name=bandwidthWindow" json:"bandwidthWindow,omitempty"`
+	UploadRateLimit   int64             `protobuf:"varint,3,opt,name=uploadRateLimit" json:"uploadRateLimit,omitempty"`
+	DownloadRateLimit int64             `protobuf:"varint,4,opt,name=downloadRateLimit" json:"downloadRateLimit,omitempty"`
 }
 
 func (m *StatusResponse) Reset()                    { *m = StatusResponse{} }
@@ -159,6 +177,130 @@ func (m *StatusResponse) GetLayerDownStates() []*LayerDownState {
 	return nil
 }
 
+type GetSignaturesRequest struct {
+	Source string `protobuf:"bytes,1,opt,name=source" json:"source,omitempty"`
+}
+
+func (m *GetSignaturesRequest) Reset()                    { *m = GetSignaturesRequest{} }
+func (m *GetSignaturesRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetSignaturesRequest) ProtoMessage()               {}
+func (*GetSignaturesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+type GetSignaturesResponse struct {
+	Signatures [][]byte `protobuf:"bytes,1,rep,name=signatures,proto3" json:"signatures,omitempty"`
+}
+
+func (m *GetSignaturesResponse) Reset()                    { *m = GetSignaturesResponse{} }
+func (m *GetSignaturesResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetSignaturesResponse) ProtoMessage()               {}
+func (*GetSignaturesResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+type SetRateLimitRequest struct {
+	Source   string `protobuf:"bytes,1,opt,name=source" json:"source,omitempty"`
+	Id       string `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Upload   int64  `protobuf:"varint,3,opt,name=upload" json:"upload,omitempty"`
+	Download int64  `protobuf:"varint,4,opt,name=download" json:"download,omitempty"`
+}
+
+func (m *SetRateLimitRequest) Reset()                    { *m = SetRateLimitRequest{} }
+func (m *SetRateLimitRequest) String() string            { return proto.CompactTextString(m) }
+func (*SetRateLimitRequest) ProtoMessage()               {}
+func (*SetRateLimitRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+type SetRateLimitResponse struct {
+}
+
+func (m *SetRateLimitResponse) Reset()                    { *m = SetRateLimitResponse{} }
+func (m *SetRateLimitResponse) String() string            { return proto.CompactTextString(m) }
+func (*SetRateLimitResponse) ProtoMessage()               {}
+func (*SetRateLimitResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+type GetRateLimitRequest struct {
+	Source string `protobuf:"bytes,1,opt,name=source" json:"source,omitempty"`
+	Id     string `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *GetRateLimitRequest) Reset()                    { *m = GetRateLimitRequest{} }
+func (m *GetRateLimitRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetRateLimitRequest) ProtoMessage()               {}
+func (*GetRateLimitRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+type GetRateLimitResponse struct {
+	Upload   int64 `protobuf:"varint,1,opt,name=upload" json:"upload,omitempty"`
+	Download int64 `protobuf:"varint,2,opt,name=download" json:"download,omitempty"`
+}
+
+func (m *GetRateLimitResponse) Reset()                    { *m = GetRateLimitResponse{} }
+func (m *GetRateLimitResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetRateLimitResponse) ProtoMessage()               {}
+func (*GetRateLimitResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+type GetDaemonInfoRequest struct {
+}
+
+func (m *GetDaemonInfoRequest) Reset()                    { *m = GetDaemonInfoRequest{} }
+func (m *GetDaemonInfoRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetDaemonInfoRequest) ProtoMessage()               {}
+func (*GetDaemonInfoRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+type GetDaemonInfoResponse struct {
+	BtEnabled  bool     `protobuf:"varint,1,opt,name=btEnabled" json:"btEnabled,omitempty"`
+	Seeder     bool     `protobuf:"varint,2,opt,name=seeder" json:"seeder,omitempty"`
+	ListenAddr string   `protobuf:"bytes,3,opt,name=listenAddr" json:"listenAddr,omitempty"`
+	Tcp        bool     `protobuf:"varint,4,opt,name=tcp" json:"tcp,omitempty"`
+	Utp        bool     `protobuf:"varint,5,opt,name=utp" json:"utp,omitempty"`
+	Encryption string   `protobuf:"bytes,6,opt,name=encryption" json:"encryption,omitempty"`
+	Dht        bool     `protobuf:"varint,7,opt,name=dht" json:"dht,omitempty"`
+	Lsd        bool     `protobuf:"varint,8,opt,name=lsd" json:"lsd,omitempty"`
+	Private    bool     `protobuf:"varint,9,opt,name=private" json:"private,omitempty"`
+	Zone       string   `protobuf:"bytes,10,opt,name=zone" json:"zone,omitempty"`
+	Rack       string   `protobuf:"bytes,11,opt,name=rack" json:"rack,omitempty"`
+	Trackers   []string `protobuf:"bytes,12,rep,name=trackers" json:"trackers,omitempty"`
+}
+
+func (m *GetDaemonInfoResponse) Reset()                    { *m = GetDaemonInfoResponse{} }
+func (m *GetDaemonInfoResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetDaemonInfoResponse) ProtoMessage()               {}
+func (*GetDaemonInfoResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+type GetMagnetsRequest struct {
+	Source string `protobuf:"bytes,1,opt,name=source" json:"source,omitempty"`
+	Id     string `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+}
+
+func (m *GetMagnetsRequest) Reset()                    { *m = GetMagnetsRequest{} }
+func (m *GetMagnetsRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetMagnetsRequest) ProtoMessage()               {}
+func (*GetMagnetsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+type LayerMagnet struct {
+	Id     string   `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Magnet string   `protobuf:"bytes,2,opt,name=magnet" json:"magnet,omitempty"`
+	Peers  []string `protobuf:"bytes,3,rep,name=peers" json:"peers,omitempty"`
+}
+
+func (m *LayerMagnet) Reset()                    { *m = LayerMagnet{} }
+func (m *LayerMagnet) String() string            { return proto.CompactTextString(m) }
+func (*LayerMagnet) ProtoMessage()               {}
+func (*LayerMagnet) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+type GetMagnetsResponse struct {
+	Magnets    []*LayerMagnet `protobuf:"bytes,1,rep,name=magnets" json:"magnets,omitempty"`
+	ListenAddr string         `protobuf:"bytes,2,opt,name=listenAddr" json:"listenAddr,omitempty"`
+}
+
+func (m *GetMagnetsResponse) Reset()                    { *m = GetMagnetsResponse{} }
+func (m *GetMagnetsResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetMagnetsResponse) ProtoMessage()               {}
+func (*GetMagnetsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+func (m *GetMagnetsResponse) GetMagnets() []*LayerMagnet {
+	if m != nil {
+		return m.Magnets
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*GetServerVersionRequest)(nil), "types.GetServerVersionRequest")
 	proto.RegisterType((*GetServerVersionResponse)(nil), "types.GetServerVersionResponse")
@@ -171,6 +313,17 @@ func init() {
 	proto.RegisterType((*StatusRequest)(nil), "types.StatusRequest")
 	proto.RegisterType((*LayerDownState)(nil), "types.LayerDownState")
 	proto.RegisterType((*StatusResponse)(nil), "types.StatusResponse")
+	proto.RegisterType((*GetSignaturesRequest)(nil), "types.GetSignaturesRequest")
+	proto.RegisterType((*GetSignaturesResponse)(nil), "types.GetSignaturesResponse")
+	proto.RegisterType((*SetRateLimitRequest)(nil), "types.SetRateLimitRequest")
+	proto.RegisterType((*SetRateLimitResponse)(nil), "types.SetRateLimitResponse")
+	proto.RegisterType((*GetRateLimitRequest)(nil), "types.GetRateLimitRequest")
+	proto.RegisterType((*GetRateLimitResponse)(nil), "types.GetRateLimitResponse")
+	proto.RegisterType((*GetDaemonInfoRequest)(nil), "types.GetDaemonInfoRequest")
+	proto.RegisterType((*GetDaemonInfoResponse)(nil), "types.GetDaemonInfoResponse")
+	proto.RegisterType((*GetMagnetsRequest)(nil), "types.GetMagnetsRequest")
+	proto.RegisterType((*LayerMagnet)(nil), "types.LayerMagnet")
+	proto.RegisterType((*GetMagnetsResponse)(nil), "types.GetMagnetsResponse")
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -189,6 +342,11 @@ type APIClient interface {
 	StopDownload(ctx context.Context, in *StopDownloadRequest, opts ...grpc.CallOption) (*StopDownloadResponse, error)
 	GetTorrent(ctx context.Context, in *GetTorrentRequest, opts ...grpc.CallOption) (*GetTorrentResponse, error)
 	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	GetSignatures(ctx context.Context, in *GetSignaturesRequest, opts ...grpc.CallOption) (*GetSignaturesResponse, error)
+	SetRateLimit(ctx context.Context, in *SetRateLimitRequest, opts ...grpc.CallOption) (*SetRateLimitResponse, error)
+	GetRateLimit(ctx context.Context, in *GetRateLimitRequest, opts ...grpc.CallOption) (*GetRateLimitResponse, error)
+	GetDaemonInfo(ctx context.Context, in *GetDaemonInfoRequest, opts ...grpc.CallOption) (*GetDaemonInfoResponse, error)
+	GetMagnets(ctx context.Context, in *GetMagnetsRequest, opts ...grpc.CallOption) (*GetMagnetsResponse, error)
 }
 
 type aPIClient struct {
@@ -244,6 +402,51 @@ func (c *aPIClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.
 	return out, nil
 }
 
+func (c *aPIClient) GetSignatures(ctx context.Context, in *GetSignaturesRequest, opts ...grpc.CallOption) (*GetSignaturesResponse, error) {
+	out := new(GetSignaturesResponse)
+	err := grpc.Invoke(ctx, "/types.API/GetSignatures", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) SetRateLimit(ctx context.Context, in *SetRateLimitRequest, opts ...grpc.CallOption) (*SetRateLimitResponse, error) {
+	out := new(SetRateLimitResponse)
+	err := grpc.Invoke(ctx, "/types.API/SetRateLimit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) GetRateLimit(ctx context.Context, in *GetRateLimitRequest, opts ...grpc.CallOption) (*GetRateLimitResponse, error) {
+	out := new(GetRateLimitResponse)
+	err := grpc.Invoke(ctx, "/types.API/GetRateLimit", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) GetDaemonInfo(ctx context.Context, in *GetDaemonInfoRequest, opts ...grpc.CallOption) (*GetDaemonInfoResponse, error) {
+	out := new(GetDaemonInfoResponse)
+	err := grpc.Invoke(ctx, "/types.API/GetDaemonInfo", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *aPIClient) GetMagnets(ctx context.Context, in *GetMagnetsRequest, opts ...grpc.CallOption) (*GetMagnetsResponse, error) {
+	out := new(GetMagnetsResponse)
+	err := grpc.Invoke(ctx, "/types.API/GetMagnets", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for API service
 
 type APIServer interface {
@@ -252,6 +455,11 @@ type APIServer interface {
 	StopDownload(context.Context, *StopDownloadRequest) (*StopDownloadResponse, error)
 	GetTorrent(context.Context, *GetTorrentRequest) (*GetTorrentResponse, error)
 	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	GetSignatures(context.Context, *GetSignaturesRequest) (*GetSignaturesResponse, error)
+	SetRateLimit(context.Context, *SetRateLimitRequest) (*SetRateLimitResponse, error)
+	GetRateLimit(context.Context, *GetRateLimitRequest) (*GetRateLimitResponse, error)
+	GetDaemonInfo(context.Context, *GetDaemonInfoRequest) (*GetDaemonInfoResponse, error)
+	GetMagnets(context.Context, *GetMagnetsRequest) (*GetMagnetsResponse, error)
 }
 
 func RegisterAPIServer(s *grpc.Server, srv APIServer) {
@@ -348,6 +556,96 @@ func _API_Status_Handler(srv interface{}, ctx context.Context, dec func(interfac
 	return interceptor(ctx, in, info, handler)
 }
 
+func _API_GetSignatures_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSignaturesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).GetSignatures(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/types.API/GetSignatures",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).GetSignatures(ctx, req.(*GetSignaturesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_SetRateLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRateLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).SetRateLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/types.API/SetRateLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).SetRateLimit(ctx, req.(*SetRateLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_GetRateLimit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRateLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).GetRateLimit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/types.API/GetRateLimit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).GetRateLimit(ctx, req.(*GetRateLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_GetDaemonInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDaemonInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).GetDaemonInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/types.API/GetDaemonInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).GetDaemonInfo(ctx, req.(*GetDaemonInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _API_GetMagnets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMagnetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(APIServer).GetMagnets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/types.API/GetMagnets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(APIServer).GetMagnets(ctx, req.(*GetMagnetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _API_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "types.API",
 	HandlerType: (*APIServer)(nil),
@@ -372,6 +670,26 @@ var _API_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Status",
 			Handler:    _API_Status_Handler,
 		},
+		{
+			MethodName: "GetSignatures",
+			Handler:    _API_GetSignatures_Handler,
+		},
+		{
+			MethodName: "SetRateLimit",
+			Handler:    _API_SetRateLimit_Handler,
+		},
+		{
+			MethodName: "GetRateLimit",
+			Handler:    _API_GetRateLimit_Handler,
+		},
+		{
+			MethodName: "GetDaemonInfo",
+			Handler:    _API_GetDaemonInfo_Handler,
+		},
+		{
+			MethodName: "GetMagnets",
+			Handler:    _API_GetMagnets_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: fileDescriptor0,
@@ -380,37 +698,66 @@ var _API_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("api.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 508 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0x8c, 0x54, 0x4d, 0x73, 0xd3, 0x30,
-	0x10, 0x25, 0x71, 0x13, 0x92, 0xa5, 0x0d, 0x45, 0x24, 0xd4, 0x75, 0x3b, 0xc0, 0x88, 0x03, 0x3d,
-	0xe5, 0x50, 0x0e, 0x1c, 0x19, 0xa6, 0xcc, 0x30, 0x9d, 0xe9, 0x01, 0x14, 0xe0, 0x2e, 0xe2, 0x1d,
-	0x30, 0x93, 0x58, 0x46, 0x92, 0x5b, 0xca, 0x91, 0x1f, 0xc1, 0x1f, 0xe5, 0x0f, 0xa0, 0x2f, 0xdb,
-	0x89, 0xeb, 0x0e, 0xdc, 0xf4, 0xde, 0x4a, 0xbb, 0x6f, 0x77, 0x9f, 0x0d, 0x63, 0x5e, 0x64, 0xf3,
-	0x42, 0x0a, 0x2d, 0xc8, 0x40, 0x5f, 0x17, 0xa8, 0xe8, 0x21, 0x1c, 0xbc, 0x45, 0xbd, 0x40, 0x79,
-	0x89, 0xf2, 0x13, 0x4a, 0x95, 0x89, 0x9c, 0xe1, 0xf7, 0x12, 0x95, 0xa6, 0x3f, 0x20, 0xbe, 0x19,
-	0x52, 0x85, 0xc8, 0x15, 0x92, 0x29, 0x0c, 0xd6, 0xfc, 0x9b, 0x90, 0x71, 0xef, 0x69, 0xef, 0x64,
-	0x8f, 0x79, 0xe0, 0xd8, 0x2c, 0x37, 0x6c, 0x3f, 0xb0, 0x16, 0x58, 0xb6, 0xe0, 0x7a, 0xf9, 0x35,
-	0x8e, 0x3c, 0xeb, 0x00, 0x49, 0x60, 0x24, 0xf1, 0x32, 0xb3, 0x59, 0xe3, 0x1d, 0x13, 0x18, 0xb3,
-	0x1a, 0xd3, 0xdf, 0x3d, 0x98, 0x2e, 0x34, 0x97, 0xfa, 0x8d, 0xb8, 0xca, 0x57, 0x82, 0xa7, 0x41,
-	0x12, 0x79, 0x04, 0x43, 0x25, 0x4a, 0xb9, 0x44, 0x57, 0x77, 0xcc, 0x02, 0x72, 0xbc, 0x4e, 0x45,
-	0xa9, 0x5d, 0x65, 0xcb, 0x3b, 0x14, 0x78, 0x94, 0xd2, 0xd5, 0xf6, 0xbc, 0x41, 0xb6, 0x78, 0xa9,
-	0x50, 0xe6, 0x7c, 0x8d, 0x55, 0xf1, 0x0a, 0xdb, 0x58, 0xc1, 0x95, 0xba, 0x12, 0x32, 0x8d, 0x07,
-	0x3e, 0x56, 0x61, 0x7a, 0x00, 0xb3, 0x96, 0x2e, 0x3f, 0x0f, 0x7a, 0x06, 0x0f, 0x17, 0x5a, 0x14,
-	0xff, 0xab, 0xd7, 0x8c, 0x64, 0xb9, 0x42, 0x9e, 0x3b, 0xb9, 0x23, 0xe6, 0x01, 0x3d, 0xb1, 0x5d,
-	0x6f, 0x26, 0x09, 0xc3, 0xde, 0x87, 0x28, 0x4b, 0x95, 0x49, 0x11, 0x99, 0x14, 0xf6, 0x48, 0x9f,
-	0xc1, 0x03, 0xb3, 0x9a, 0x0f, 0x42, 0x4a, 0xcc, 0x75, 0x55, 0x6c, 0x02, 0xfd, 0x2c, 0x0d, 0x85,
-	0xcc, 0x89, 0xce, 0x81, 0x6c, 0x5e, 0x0a, 0xc9, 0x62, 0xb8, 0xab, 0x3d, 0xe5, 0xae, 0xee, 0xb2,
-	0x0a, 0xd2, 0xe7, 0xb0, 0x67, 0x9a, 0xd3, 0xa5, 0xfa, 0x87, 0x7a, 0xfa, 0xab, 0x07, 0x93, 0x0b,
-	0x7e, 0x8d, 0xd2, 0x2a, 0xb5, 0x4f, 0xb0, 0x5d, 0xdb, 0x36, 0xa8, 0x6c, 0x20, 0xec, 0xc3, 0x03,
-	0x72, 0x0c, 0xe3, 0xa5, 0x58, 0x17, 0x2b, 0xd4, 0x98, 0xba, 0x8d, 0x44, 0xac, 0x21, 0x08, 0x81,
-	0x1d, 0x95, 0xfd, 0xf4, 0x0b, 0x89, 0x98, 0x3b, 0x5b, 0xb5, 0x0a, 0x31, 0xcd, 0xf2, 0x2f, 0x6e,
-	0x17, 0x23, 0x56, 0x41, 0xfa, 0x1e, 0x26, 0x95, 0xda, 0xd0, 0xd9, 0x2b, 0xb8, 0xbf, 0xda, 0x52,
-	0xe5, 0x47, 0x76, 0xef, 0x74, 0x36, 0x77, 0x5e, 0x9f, 0x6f, 0x6b, 0x66, 0xed, 0xdb, 0xa7, 0x7f,
-	0xfa, 0x10, 0xbd, 0x7e, 0x77, 0x4e, 0x3e, 0xc2, 0x7e, 0xdb, 0xf8, 0xe4, 0x71, 0xc8, 0x71, 0xcb,
-	0xc7, 0x92, 0x3c, 0xb9, 0x35, 0x1e, 0x1c, 0x72, 0x87, 0x5c, 0xb8, 0xf9, 0x36, 0xe6, 0x21, 0x47,
-	0xe1, 0x4d, 0x97, 0xd5, 0x93, 0xe3, 0xee, 0x60, 0x9d, 0xed, 0x1c, 0x76, 0x37, 0xcd, 0x42, 0x92,
-	0xfa, 0xfe, 0x0d, 0x1b, 0x26, 0x47, 0x9d, 0xb1, 0x3a, 0xd5, 0x19, 0x40, 0x63, 0x14, 0x12, 0x37,
-	0x9d, 0x6c, 0x1b, 0x2c, 0x39, 0xec, 0x88, 0xd4, 0x49, 0x5e, 0xc2, 0xd0, 0xef, 0x83, 0x4c, 0x1b,
-	0xe5, 0x8d, 0x99, 0x92, 0x59, 0x8b, 0xad, 0x1e, 0x7e, 0x1e, 0xba, 0xff, 0xd1, 0x8b, 0xbf, 0x01,
-	0x00, 0x00, 0xff, 0xff, 0x0d, 0xb0, 0x4b, 0x1b, 0x9c, 0x04, 0x00, 0x00,
+	// 968 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0xcb, 0x6e, 0x23, 0x45,
+	0x17, 0xfe, 0xed, 0x1e, 0x3b, 0xf6, 0xc9, 0x65, 0x32, 0x15, 0x3b, 0xd3, 0xe9, 0x58, 0xf9, 0xa3,
+	0x46, 0x02, 0x2f, 0x46, 0x59, 0x0c, 0x8b, 0x59, 0x20, 0x84, 0x46, 0x33, 0xc8, 0x0a, 0x04, 0x81,
+	0xda, 0x5c, 0xd6, 0x6d, 0xd7, 0x21, 0x29, 0xb0, 0xab, 0x7a, 0xaa, 0xca, 0x09, 0x99, 0x25, 0xaf,
+	0xc4, 0xa3, 0xb0, 0xe7, 0x01, 0x78, 0x0a, 0x54, 0x97, 0xbe, 0xba, 0x43, 0x80, 0x5d, 0x9d, 0xef,
+	0x54, 0x9d, 0x6b, 0x9f, 0xef, 0x34, 0x0c, 0xd3, 0x8c, 0x5d, 0x64, 0x52, 0x68, 0x41, 0x7a, 0xfa,
+	0x3e, 0x43, 0x15, 0x9f, 0xc0, 0xf3, 0x19, 0xea, 0x39, 0xca, 0x5b, 0x94, 0xdf, 0xa3, 0x54, 0x4c,
+	0xf0, 0x04, 0xdf, 0x6d, 0x50, 0xe9, 0xf8, 0x17, 0x08, 0xb7, 0x55, 0x2a, 0x13, 0x5c, 0x21, 0x19,
+	0x41, 0x6f, 0x9d, 0xfe, 0x24, 0x64, 0xd8, 0x39, 0xef, 0x4c, 0xf7, 0x13, 0x27, 0x58, 0x94, 0x71,
+	0x21, 0xc3, 0xae, 0x47, 0x19, 0x77, 0x68, 0x96, 0xea, 0xe5, 0x4d, 0x18, 0x38, 0xd4, 0x0a, 0x24,
+	0x82, 0x81, 0xc4, 0x5b, 0x66, 0xac, 0x86, 0x4f, 0xce, 0x3b, 0xd3, 0x61, 0x52, 0xc8, 0xf1, 0x1f,
+	0x1d, 0x18, 0xcd, 0x75, 0x2a, 0xf5, 0x5b, 0x71, 0xc7, 0x57, 0x22, 0xa5, 0x3e, 0x24, 0x72, 0x0c,
+	0x7d, 0x25, 0x36, 0x72, 0x89, 0xd6, 0xef, 0x30, 0xf1, 0x92, 0xc5, 0x35, 0x15, 0x1b, 0x1d, 0x76,
+	0x3d, 0x6e, 0x25, 0x8f, 0xa3, 0x94, 0x61, 0x50, 0xe0, 0x28, 0xa5, 0x71, 0xbe, 0x51, 0x28, 0x79,
+	0xba, 0xc6, 0xdc, 0x79, 0x2e, 0x1b, 0x5d, 0x96, 0x2a, 0x75, 0x27, 0x24, 0x0d, 0x7b, 0x4e, 0x97,
+	0xcb, 0xe4, 0x0c, 0x60, 0x95, 0xde, 0xa3, 0xfc, 0x5a, 0x52, 0x94, 0x61, 0xdf, 0x6a, 0x2b, 0x08,
+	0xf9, 0x10, 0x0e, 0x32, 0xc9, 0x84, 0x64, 0xfa, 0xfe, 0xca, 0xa0, 0x2a, 0xdc, 0x39, 0x0f, 0xa6,
+	0xc3, 0xa4, 0x81, 0xc6, 0xcf, 0x61, 0xdc, 0xc8, 0xcf, 0xd5, 0x35, 0x7e, 0x03, 0x47, 0x73, 0x2d,
+	0xb2, 0x7f, 0x9a, 0xf7, 0x08, 0x7a, 0xcb, 0x15, 0xa6, 0xdc, 0xa6, 0x3d, 0x48, 0x9c, 0x10, 0x4f,
+	0x61, 0x54, 0x37, 0xe2, 0x9b, 0x76, 0x08, 0x01, 0xa3, 0x2a, 0xec, 0xd8, 0x90, 0xcc, 0x31, 0xfe,
+	0x00, 0x9e, 0xcd, 0x50, 0x7f, 0x2b, 0xa4, 0x44, 0xae, 0x73, 0x67, 0x07, 0xd0, 0x65, 0xd4, 0x3b,
+	0xea, 0x32, 0x1a, 0x53, 0x20, 0xd5, 0x4b, 0xde, 0x58, 0x08, 0x3b, 0xda, 0x41, 0xf6, 0xea, 0x5e,
+	0x92, 0x8b, 0xb6, 0x48, 0x4c, 0x69, 0xe4, 0xaf, 0x29, 0x95, 0xbe, 0x21, 0x15, 0xc4, 0x7e, 0x0f,
+	0x68, 0x6a, 0x13, 0xd8, 0x40, 0x9c, 0x10, 0x7f, 0x04, 0xfb, 0x73, 0x9d, 0xea, 0x8d, 0x7a, 0x24,
+	0xe7, 0xf8, 0xd7, 0x0e, 0x1c, 0xd8, 0x32, 0x9a, 0xfc, 0xcc, 0x13, 0x6c, 0x46, 0x6c, 0x3c, 0x28,
+	0xa3, 0xf0, 0xce, 0x9d, 0x40, 0x26, 0x30, 0x5c, 0x8a, 0x75, 0xb6, 0x42, 0x8d, 0xd4, 0x7e, 0x0f,
+	0x41, 0x52, 0x02, 0x84, 0xc0, 0x13, 0xc5, 0xde, 0xbb, 0xcf, 0x21, 0x48, 0xec, 0xd9, 0xe4, 0xa8,
+	0x10, 0x29, 0xe3, 0xd7, 0xf6, 0x4b, 0x18, 0x24, 0xb9, 0x18, 0xff, 0xde, 0x81, 0x83, 0x3c, 0x5c,
+	0x5f, 0x90, 0xcf, 0xe0, 0xe9, 0xaa, 0x16, 0x96, 0xab, 0xf4, 0xee, 0xcb, 0xf1, 0x85, 0x1d, 0xb5,
+	0x8b, 0x7a, 0xd0, 0x49, 0xf3, 0x36, 0x99, 0xc2, 0xd3, 0x45, 0xca, 0xe9, 0x1d, 0xa3, 0xfa, 0xe6,
+	0x07, 0xc6, 0xa9, 0xb8, 0xf3, 0xf1, 0x37, 0x61, 0x73, 0x73, 0x93, 0xd9, 0xd6, 0xa6, 0x1a, 0xaf,
+	0xd8, 0x9a, 0x69, 0x9f, 0x4f, 0x13, 0x26, 0x2f, 0xe0, 0x19, 0xcd, 0x3f, 0x83, 0xe2, 0xae, 0x4b,
+	0x71, 0x5b, 0x11, 0x5f, 0xc0, 0xc8, 0x4c, 0x3c, 0xbb, 0xe6, 0xa9, 0xde, 0x48, 0x7c, 0xb4, 0x15,
+	0xaf, 0x60, 0xdc, 0xb8, 0xef, 0x6b, 0x71, 0x06, 0xa0, 0x0a, 0xd4, 0x96, 0x61, 0x2f, 0xa9, 0x20,
+	0xf1, 0x3b, 0x38, 0x9a, 0xa3, 0x2e, 0x1c, 0x3f, 0xf6, 0x99, 0xbb, 0xfe, 0x76, 0x8b, 0xfe, 0x1e,
+	0x43, 0xdf, 0x25, 0xea, 0xd3, 0xf6, 0x92, 0x19, 0xdd, 0x3c, 0x29, 0x9f, 0x64, 0x21, 0xc7, 0xc7,
+	0x30, 0xaa, 0xbb, 0xf4, 0x13, 0xf7, 0x29, 0x1c, 0xcd, 0xfe, 0x7b, 0x28, 0xf1, 0x17, 0x30, 0xaa,
+	0x3f, 0xf7, 0x15, 0x28, 0x43, 0xec, 0x3c, 0x18, 0x62, 0x77, 0x3b, 0xc4, 0x19, 0xea, 0xb7, 0x29,
+	0xae, 0x05, 0xbf, 0xe4, 0x3f, 0x8a, 0x9c, 0x88, 0x7f, 0xeb, 0xc2, 0xb8, 0xa1, 0xf0, 0x5e, 0x26,
+	0x30, 0x5c, 0xe8, 0xcf, 0x79, 0xba, 0x58, 0xa1, 0x73, 0x34, 0x48, 0x4a, 0xc0, 0xe6, 0x80, 0x68,
+	0x98, 0xca, 0xd1, 0x83, 0x97, 0x1a, 0x03, 0x1a, 0x6c, 0x0d, 0xe8, 0x21, 0x04, 0x7a, 0x99, 0xd9,
+	0x0a, 0x0e, 0x12, 0x73, 0x34, 0xc8, 0x46, 0x67, 0x7e, 0x08, 0xcc, 0xd1, 0xd8, 0x40, 0xbe, 0x94,
+	0xf7, 0x99, 0x36, 0x04, 0xee, 0x99, 0xb0, 0x44, 0xcc, 0x0b, 0x7a, 0xa3, 0xc3, 0x1d, 0xf7, 0x82,
+	0xde, 0x68, 0x83, 0xac, 0x14, 0x0d, 0x07, 0x0e, 0x59, 0x29, 0x6a, 0xc6, 0x2b, 0x93, 0xec, 0xd6,
+	0x0c, 0xea, 0xd0, 0x8d, 0x97, 0x17, 0xcd, 0x30, 0xbe, 0x17, 0x1c, 0x43, 0xb0, 0x76, 0xed, 0xd9,
+	0x60, 0x32, 0x5d, 0xfe, 0x1c, 0xee, 0x3a, 0xcc, 0x9c, 0x4d, 0x35, 0xb5, 0x39, 0x18, 0x36, 0xd9,
+	0xb3, 0x6c, 0x52, 0xc8, 0xf1, 0x27, 0x96, 0xdb, 0xbe, 0x4a, 0xaf, 0x39, 0x6a, 0xf5, 0x6f, 0xdb,
+	0xfa, 0x25, 0xec, 0xda, 0x71, 0x75, 0xcf, 0xb7, 0x08, 0xe6, 0x18, 0xfa, 0x6b, 0xab, 0xc9, 0xf7,
+	0x8d, 0x93, 0x1e, 0xa0, 0xb6, 0x85, 0x25, 0xd0, 0x22, 0x12, 0xdf, 0xbb, 0x17, 0xb0, 0xe3, 0x5e,
+	0xe5, 0x3c, 0x41, 0xaa, 0x3c, 0xe1, 0x6e, 0x27, 0xf9, 0x95, 0xc7, 0x48, 0xf5, 0xe5, 0x9f, 0x3d,
+	0x08, 0x5e, 0x7f, 0x73, 0x49, 0xbe, 0x83, 0xc3, 0xe6, 0xd2, 0x26, 0x67, 0xde, 0xf0, 0x03, 0x8b,
+	0x3e, 0xfa, 0xff, 0x83, 0x7a, 0x3f, 0x23, 0xff, 0x23, 0x57, 0xb0, 0x5f, 0x5b, 0x58, 0xe4, 0xd4,
+	0xbf, 0x69, 0x5b, 0xd3, 0xd1, 0xa4, 0x5d, 0x59, 0x58, 0xbb, 0x84, 0xbd, 0xea, 0x82, 0x22, 0x51,
+	0x71, 0x7f, 0x6b, 0xf5, 0x45, 0xa7, 0xad, 0xba, 0xc2, 0xd4, 0x1b, 0x80, 0x72, 0x39, 0x91, 0xb0,
+	0xcc, 0xa4, 0xbe, 0xd4, 0xa2, 0x93, 0x16, 0x4d, 0x61, 0xe4, 0x15, 0xf4, 0x1d, 0x99, 0x93, 0x51,
+	0x19, 0x79, 0xb9, 0x8a, 0xa2, 0x71, 0x03, 0xad, 0x96, 0xa5, 0x46, 0x80, 0x45, 0x59, 0xda, 0x68,
+	0x34, 0x9a, 0xb4, 0x2b, 0x6b, 0x65, 0xa9, 0x70, 0x49, 0x59, 0x96, 0x6d, 0x7e, 0x8a, 0x4e, 0x5b,
+	0x75, 0x55, 0x53, 0xb3, 0x36, 0x53, 0xb3, 0xbf, 0x31, 0x35, 0x6b, 0x37, 0xe5, 0x72, 0x2c, 0xc9,
+	0xa7, 0x9a, 0xe3, 0x16, 0x57, 0x45, 0x93, 0x76, 0x65, 0xa3, 0x5f, 0x7e, 0x16, 0xaa, 0xfd, 0xaa,
+	0x0f, 0x6a, 0x74, 0xd2, 0xa2, 0xc9, 0x8d, 0x2c, 0xfa, 0xf6, 0x17, 0xf6, 0xe3, 0xbf, 0x06, 0x00,
+	0xf9, 0xda, 0xbf, 0x30, 0xcf, 0x0a, 0x00, 0x00,
 }
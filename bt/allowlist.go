@@ -0,0 +1,45 @@
+package bt
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// ipAllowList is an iplist.Ranger blocking the addresses outside of its
+// subnets. Blocklists only support IPv4, IPv6 peers are always blocked.
+type ipAllowList []*net.IPNet
+
+// ParseAllowedPeers parses the IPv4 CIDR subnets peers are allowed from
+func ParseAllowedPeers(subnets []string) (iplist.Ranger, error) {
+	var l ipAllowList
+	for _, s := range subnets {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid peer subnet %s: %v", s, err)
+		}
+		if n.IP.To4() == nil {
+			return nil, fmt.Errorf("Invalid peer subnet %s: only IPv4 is supported", s)
+		}
+		l = append(l, n)
+	}
+	return l, nil
+}
+
+func (l ipAllowList) Lookup(ip net.IP) (iplist.Range, bool) {
+	for _, n := range l {
+		if n.Contains(ip) {
+			return iplist.Range{}, false
+		}
+	}
+	return iplist.Range{
+		First:       ip,
+		Last:        ip,
+		Description: "not in allowed subnets",
+	}, true
+}
+
+func (l ipAllowList) NumRanges() int {
+	return len(l)
+}
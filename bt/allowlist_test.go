@@ -0,0 +1,29 @@
+package bt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPAllowList(t *testing.T) {
+	l, err := ParseAllowedPeers([]string{"10.0.0.0/8", "192.168.1.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, blocked := range map[string]bool{
+		"10.1.2.3":    false,
+		"192.168.1.9": false,
+		"192.168.2.9": true,
+		"8.8.8.8":     true,
+	} {
+		if _, b := l.Lookup(net.ParseIP(ip).To4()); b != blocked {
+			t.Errorf("%s: expected blocked %v, got %v", ip, blocked, b)
+		}
+	}
+
+	for _, s := range [][]string{{"10.0.0.0"}, {"fd00::/8"}} {
+		if _, err := ParseAllowedPeers(s); err == nil {
+			t.Errorf("expected error for %v", s)
+		}
+	}
+}
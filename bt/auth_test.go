@@ -0,0 +1,86 @@
+package bt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// TestPeerSecret checks that only the peers proving the secret of the
+// swarm download from it. It relies on hack/patches/06-peer-auth.patch and
+// 11-peer-auth-handshake.patch.
+func TestPeerSecret(t *testing.T) {
+	port := 53000
+	newEngine := func(secret string) *BtEngine {
+		root, err := ioutil.TempDir("", "bt-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := NewBtEngine(root, nil, &Config{
+			RequireEncryption: true,
+			DisableUTP:        true,
+			EnableUpload:      true,
+			EnableSeeding:     true,
+			ListenHost:        "127.0.0.1",
+			IncomingPort:      port,
+			MaxPort:           port + 100,
+			PieceLength:       16 << 10,
+			Private:           true,
+			PeerSecret:        secret,
+		})
+		if err = e.Run(); err != nil {
+			t.Fatal(err)
+		}
+		_, p, _ := net.SplitHostPort(e.ListenAddr())
+		fmt.Sscan(p, &port)
+		port++
+		return e
+	}
+	cleanup := func(e *BtEngine) {
+		e.client.Close()
+		os.RemoveAll(e.rootDir)
+	}
+
+	content := bytes.Repeat([]byte("layer"), 20000)
+	id := fmt.Sprintf("%x", sha256.Sum256(content))
+	seeder := newEngine("secret")
+	defer cleanup(seeder)
+	if err := ioutil.WriteFile(seeder.GetFilePath(id), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := seeder.StartSeed(id); err != nil {
+		t.Fatal(err)
+	}
+	torrent, err := seeder.GetTorrent(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := []string{seeder.ListenAddr()}
+
+	other := newEngine("other")
+	defer cleanup(other)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := other.StartLeecher(ctx, id, torrent, peers, nil); err == nil {
+		t.Errorf("expected a peer with another secret to be refused")
+	}
+
+	leecher := newEngine("secret")
+	defer cleanup(leecher)
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := leecher.StartLeecher(ctx, id, torrent, peers, nil); err != nil {
+		t.Fatalf("StartLeecher: unexpected error: %s", err)
+	}
+	data, err := ioutil.ReadFile(leecher.GetFilePath(id))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("expected the layer downloaded, got %d bytes, %v", len(data), err)
+	}
+}
@@ -0,0 +1,114 @@
+package bt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	benchLayerSize = flag.Int64("bench-layer-size", 64<<20, "size of the layer distributed by BenchmarkPieceLength")
+	benchLeechers  = flag.Int("bench-leechers", 4, "number of leechers of BenchmarkPieceLength")
+	benchPort      = 52000
+)
+
+// BenchmarkPieceLength reports the time taken to distribute a layer from
+// one seeder to the leechers of an in-process swarm, for several piece
+// lengths. Leechers notice completion within 500ms, use layers large
+// enough for the download to take several seconds:
+//
+//	go test -run NONE -bench PieceLength ./bt -args -bench-layer-size=1073741824
+func BenchmarkPieceLength(b *testing.B) {
+	content := make([]byte, *benchLayerSize)
+	if _, err := rand.Read(content); err != nil {
+		b.Fatal(err)
+	}
+	id := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	for _, pieceLength := range []int64{64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20} {
+		b.Run(fmt.Sprintf("%dKB", pieceLength>>10), func(b *testing.B) {
+			b.SetBytes(*benchLayerSize)
+			for i := 0; i < b.N; i++ {
+				benchmarkSwarm(b, id, content, pieceLength)
+			}
+			b.ReportMetric(float64((*benchLayerSize+pieceLength-1)/pieceLength), "pieces")
+		})
+	}
+}
+
+// benchmarkSwarm times the download of layer id by benchLeechers leechers
+// from a new seeder, the seeder setup is not timed
+func benchmarkSwarm(b *testing.B, id string, content []byte, pieceLength int64) {
+	b.StopTimer()
+	var engines []*BtEngine
+	defer func() {
+		for _, e := range engines {
+			e.client.Close()
+			os.RemoveAll(e.rootDir)
+		}
+	}()
+	newEngine := func() *BtEngine {
+		root, err := ioutil.TempDir("", "bt-bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchPort++
+		e := NewBtEngine(root, nil, &Config{
+			DisableEncryption: true,
+			DisableUTP:        true,
+			EnableUpload:      true,
+			EnableSeeding:     true,
+			ListenHost:        "127.0.0.1",
+			IncomingPort:      benchPort,
+			MaxPort:           benchPort + 100,
+			PieceLength:       pieceLength,
+		})
+		if err = e.Run(); err != nil {
+			b.Fatal(err)
+		}
+		_, port, _ := net.SplitHostPort(e.ListenAddr())
+		benchPort, _ = strconv.Atoi(port)
+		engines = append(engines, e)
+		return e
+	}
+
+	seeder := newEngine()
+	if err := ioutil.WriteFile(seeder.GetFilePath(id), content, 0644); err != nil {
+		b.Fatal(err)
+	}
+	if err := seeder.StartSeed(id); err != nil {
+		b.Fatal(err)
+	}
+	torrent, err := seeder.GetTorrent(id)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var leechers []*BtEngine
+	for i := 0; i < *benchLeechers; i++ {
+		leechers = append(leechers, newEngine())
+	}
+	seederAddr := seeder.ListenAddr()
+
+	b.StartTimer()
+	var wg sync.WaitGroup
+	for _, l := range leechers {
+		wg.Add(1)
+		go func(l *BtEngine) {
+			defer wg.Done()
+			if err := l.StartLeecher(context.Background(), id, torrent, []string{seederAddr}, nil); err != nil {
+				b.Error(err)
+			}
+		}(l)
+	}
+	wg.Wait()
+	b.StopTimer()
+}
@@ -0,0 +1,388 @@
+package bt
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// layerBlob is a layer stored as a blob of an OCI directory. Leechers write
+// the pieces to temp, renamed to path once the layer is verified. Seeders
+// have no temp and read path.
+type layerBlob struct {
+	path string
+	temp string
+
+	mu   sync.Mutex
+	done bool // the data is at path
+}
+
+func newLayerBlob(blobPath, tempPath string) *layerBlob {
+	_, err := os.Stat(blobPath)
+	return &layerBlob{
+		path: blobPath,
+		temp: tempPath,
+		done: err == nil,
+	}
+}
+
+// committed returns whether the data is at path. Only CommitBlob moves it
+// there, so it is not checked on disk again.
+func (b *layerBlob) committed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.done
+}
+
+func blobRecordName(id string) string {
+	return id + ".blob"
+}
+
+// SetBlobPath stores the layer id in the blob at blobPath instead of the
+// data directory. The layer is downloaded to tempPath, on the same file
+// system, until CommitBlob. An empty tempPath seeds the existing blob.
+func (e *BtEngine) SetBlobPath(id, blobPath, tempPath string) error {
+	e.mut.Lock()
+	info, ok := e.idInfos[id]
+	e.mut.Unlock()
+	if ok && info.Started {
+		return fmt.Errorf("Torrent of layer %s is already started", id)
+	}
+
+	// The blob path is kept to seed the layer again after a restart
+	record := path.Join(e.dataDir, blobRecordName(id))
+	if err := ioutil.WriteFile(record, []byte(blobPath), 0600); err != nil {
+		return fmt.Errorf("Write blob record %s failed: %v", record, err)
+	}
+	// Never keep a second copy of the layer
+	if err := os.Remove(path.Join(e.dataDir, layerFileName(id))); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Remove layer file of %s failed: %v", id, err)
+	}
+
+	e.blobMut.Lock()
+	e.blobs[id] = newLayerBlob(blobPath, tempPath)
+	e.blobMut.Unlock()
+	return nil
+}
+
+func (e *BtEngine) getBlob(id string) *layerBlob {
+	e.blobMut.Lock()
+	defer e.blobMut.Unlock()
+	return e.blobs[id]
+}
+
+// removeBlob forgets the blob of layer id, the blob itself is kept
+func (e *BtEngine) removeBlob(id string) *layerBlob {
+	e.blobMut.Lock()
+	b := e.blobs[id]
+	delete(e.blobs, id)
+	e.blobMut.Unlock()
+
+	record := path.Join(e.dataDir, blobRecordName(id))
+	if err := os.Remove(record); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Remove blob record %s failed: %v", record, err)
+	}
+	return b
+}
+
+// loadBlob registers the blob recorded for layer id before a restart, if it
+// was committed
+func (e *BtEngine) loadBlob(id string) bool {
+	data, err := ioutil.ReadFile(path.Join(e.dataDir, blobRecordName(id)))
+	if err != nil {
+		return false
+	}
+	b := newLayerBlob(string(data), "")
+	if !b.committed() {
+		e.removeBlob(id)
+		return false
+	}
+
+	e.blobMut.Lock()
+	e.blobs[id] = b
+	e.blobMut.Unlock()
+	return true
+}
+
+// CommitBlob verifies the layer id downloaded to the temporary file of its
+// blob and renames it to the blob path
+func (e *BtEngine) CommitBlob(id string) error {
+	b := e.getBlob(id)
+	if b == nil || b.temp == "" {
+		return fmt.Errorf("Layer %s is not downloaded to a blob", id)
+	}
+	if b.committed() {
+		return nil
+	}
+
+	return b.commit(id)
+}
+
+// commit verifies the layer id in the temporary file and renames it to the
+// blob path
+func (b *layerBlob) commit(id string) error {
+	if err := VerifyLayerFile(b.temp, layerDigest(id)); err != nil {
+		return err
+	}
+	// The torrent keeps reading the file through its open handle
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := os.Rename(b.temp, b.path); err != nil {
+		return fmt.Errorf("Rename %s to %s failed: %v", b.temp, b.path, err)
+	}
+	b.done = true
+	log.Debugf("Commit layer %s to %s", id, b.path)
+	return nil
+}
+
+// blobStorage stores the layers with a blob and the image torrents in
+// blobs, and the other torrents in the data directory with their
+// completion
+type blobStorage struct {
+	e     *BtEngine
+	files storage.Client
+}
+
+func newBlobStorage(e *BtEngine) storage.Client {
+	return &blobStorage{
+		e: e,
+		files: &completionStorage{
+			e:     e,
+			files: storage.NewFileWithCompletion(e.dataDir, storage.PieceCompletionMap),
+		},
+	}
+}
+
+func (s *blobStorage) OpenTorrent(info *metainfo.InfoEx) (storage.Torrent, error) {
+	if len(info.Files) == 0 && filepath.Ext(info.Name) == ".layer" {
+		if b := s.e.getBlob(strings.TrimSuffix(info.Name, ".layer")); b != nil {
+			return newBlobTorrent([]*layerBlob{b}, []int64{info.Length}), nil
+		}
+	}
+	if len(info.Files) != 0 && filepath.Ext(info.Name) == ".image" {
+		if t := s.e.openImageTorrent(strings.TrimSuffix(info.Name, ".image"), info); t != nil {
+			return t, nil
+		}
+	}
+	return s.files.OpenTorrent(info)
+}
+
+// blobTorrent stores the files of a torrent in blobs, with a file handle
+// per blob kept open until the torrent is closed. The pieces of committed
+// blobs are complete.
+type blobTorrent struct {
+	files []*blobFile
+
+	mu       sync.Mutex
+	complete map[int]bool
+}
+
+// blobFile is a blob holding a file of a torrent, at offset in the torrent
+type blobFile struct {
+	blob   *layerBlob
+	offset int64
+	length int64
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func newBlobTorrent(blobs []*layerBlob, lengths []int64) *blobTorrent {
+	t := &blobTorrent{
+		complete: map[int]bool{},
+	}
+	var offset int64
+	for i, b := range blobs {
+		t.files = append(t.files, &blobFile{
+			blob:   b,
+			offset: offset,
+			length: lengths[i],
+		})
+		offset += lengths[i]
+	}
+	return t
+}
+
+// file returns the open file of the blob: the blob itself once committed,
+// else its temporary file. A missing blob without temporary file is
+// reported as os.ErrNotExist.
+func (bf *blobFile) file() (*os.File, error) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	if bf.f != nil {
+		return bf.f, nil
+	}
+
+	// Not committed while opening the temporary file
+	b := bf.blob
+	b.mu.Lock()
+	var (
+		f   *os.File
+		err error
+	)
+	if b.temp == "" || b.done {
+		f, err = os.Open(b.path)
+	} else if err = os.MkdirAll(filepath.Dir(b.temp), 0755); err == nil {
+		f, err = os.OpenFile(b.temp, os.O_RDWR|os.O_CREATE, 0644)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	bf.f = f
+	return f, nil
+}
+
+func (bf *blobFile) close() error {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	if bf.f == nil {
+		return nil
+	}
+	err := bf.f.Close()
+	bf.f = nil
+	return err
+}
+
+// extents calls fn with the files overlapping the n bytes at off in the
+// torrent, and the offset and length of the overlap in each file
+func (t *blobTorrent) extents(off, n int64, fn func(bf *blobFile, off, n int64) error) error {
+	for _, bf := range t.files {
+		if n == 0 {
+			break
+		}
+		if off >= bf.offset+bf.length || bf.length == 0 {
+			continue
+		}
+		fileOff := off - bf.offset
+		l := bf.length - fileOff
+		if l > n {
+			l = n
+		}
+		if err := fn(bf, fileOff, l); err != nil {
+			return err
+		}
+		off += l
+		n -= l
+	}
+	return nil
+}
+
+func (t *blobTorrent) Piece(p metainfo.Piece) storage.Piece {
+	return &blobPiece{
+		t: t,
+		p: p,
+	}
+}
+
+func (t *blobTorrent) Close() error {
+	var err error
+	for _, bf := range t.files {
+		if e := bf.close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+type blobPiece struct {
+	t *blobTorrent
+	p metainfo.Piece
+}
+
+// ReadAt reads len(b) bytes of the piece, or fails with io.EOF past the
+// end of the piece and io.ErrUnexpectedEOF if its data is missing
+func (bp *blobPiece) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 || off >= bp.p.Length() {
+		return 0, io.EOF
+	}
+	want := b
+	if rest := bp.p.Length() - off; int64(len(want)) > rest {
+		want = want[:rest]
+	}
+
+	n := 0
+	err := bp.t.extents(bp.p.Offset()+off, int64(len(want)), func(bf *blobFile, off, l int64) error {
+		f, err := bf.file()
+		if os.IsNotExist(err) {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		m, err := f.ReadAt(want[n:n+int(l)], off)
+		n += m
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes the pieces to the temporary files. The data of committed
+// blobs is already verified and left as is.
+func (bp *blobPiece) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(b)) > bp.p.Length() {
+		return 0, fmt.Errorf("Write beyond piece %d", bp.p.Index())
+	}
+
+	n := 0
+	err := bp.t.extents(bp.p.Offset()+off, int64(len(b)), func(bf *blobFile, off, l int64) error {
+		if bf.blob.committed() {
+			n += int(l)
+			return nil
+		}
+		if bf.blob.temp == "" {
+			return fmt.Errorf("Blob %s is read only", bf.blob.path)
+		}
+		f, err := bf.file()
+		if err != nil {
+			return err
+		}
+		m, err := f.WriteAt(b[n:n+int(l)], off)
+		n += m
+		return err
+	})
+	return n, err
+}
+
+func (bp *blobPiece) MarkComplete() error {
+	bp.t.mu.Lock()
+	bp.t.complete[bp.p.Index()] = true
+	bp.t.mu.Unlock()
+	return nil
+}
+
+// GetIsComplete trusts the blobs of the OCI directory, they are verified
+// against their digest before being stored
+func (bp *blobPiece) GetIsComplete() bool {
+	bp.t.mu.Lock()
+	complete := bp.t.complete[bp.p.Index()]
+	bp.t.mu.Unlock()
+	if complete {
+		return true
+	}
+
+	committed := true
+	bp.t.extents(bp.p.Offset(), bp.p.Length(), func(bf *blobFile, off, l int64) error {
+		committed = committed && bf.blob.committed()
+		return nil
+	})
+	return committed
+}
@@ -0,0 +1,110 @@
+package bt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "bt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	e := NewBtEngine(filepath.Join(root, "bt"), nil, &Config{})
+	if err := os.MkdirAll(e.dataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("layer"), 1000)
+	id := fmt.Sprintf("%x", sha256.Sum256(content))
+	src := filepath.Join(root, "src")
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := buildLayerInfo(id, src, 1024, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blob, temp := filepath.Join(root, "blob"), filepath.Join(root, "blob.tmp")
+	if err := e.SetBlobPath(id, blob, temp); err != nil {
+		t.Fatal(err)
+	}
+	if fn := e.GetFilePath(id); fn != blob {
+		t.Errorf("expected the blob path, got %s", fn)
+	}
+	ts, err := newBlobStorage(e).OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ts.Piece(info.Piece(0)).ReadAt(make([]byte, 10), 0); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadAt of missing data: expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	// Write the pieces in reverse order, corrupting the first one
+	for i := info.NumPieces() - 1; i >= 0; i-- {
+		p := ts.Piece(info.Piece(i))
+		if p.GetIsComplete() {
+			t.Errorf("piece %d: expected incomplete", i)
+		}
+		data := content[info.Piece(i).Offset() : info.Piece(i).Offset()+info.Piece(i).Length()]
+		if i == 0 {
+			data = bytes.ToUpper(data)
+		}
+		if _, err := p.WriteAt(data, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.MarkComplete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.CommitBlob(id); err == nil {
+		t.Fatalf("CommitBlob: expected error for corrupted data")
+	}
+	if _, err := os.Stat(blob); !os.IsNotExist(err) {
+		t.Fatalf("corrupted data committed to the blob")
+	}
+
+	if _, err := ts.Piece(info.Piece(0)).WriteAt(content[:1024], 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.CommitBlob(id); err != nil {
+		t.Fatalf("CommitBlob: unexpected error: %s", err)
+	}
+	if data, err := ioutil.ReadFile(blob); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("unexpected blob content, error %v", err)
+	}
+	b := make([]byte, 10)
+	if n, err := ts.Piece(info.Piece(1)).ReadAt(b, 0); err != nil || n != 10 || !bytes.Equal(b, content[1024:1034]) {
+		t.Errorf("ReadAt: read %q, error %v", b[:n], err)
+	}
+	// Reads are only short at the end of the piece
+	b = make([]byte, 2048)
+	if n, err := ts.Piece(info.Piece(1)).ReadAt(b, 1000); err != io.EOF || n != 24 || !bytes.Equal(b[:n], content[2024:2048]) {
+		t.Errorf("ReadAt past the piece: read %d bytes, error %v", n, err)
+	}
+	if err := ts.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// After a restart, the committed blob is found again
+	e.blobs = map[string]*layerBlob{}
+	if !e.loadBlob(id) || e.GetFilePath(id) != blob {
+		t.Errorf("expected the blob to be loaded again")
+	}
+	e.removeBlob(id)
+	if e.loadBlob(id) {
+		t.Errorf("expected the blob to be forgotten")
+	}
+	if _, err := os.Stat(blob); err != nil {
+		t.Errorf("expected the blob to be kept: %v", err)
+	}
+}
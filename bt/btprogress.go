@@ -16,26 +16,36 @@ type ProgressDownload struct {
 	bar    *pb.ProgressBar
 }
 
-func (p *ProgressDownload) waitComplete(t *Torrent) {
+func (p *ProgressDownload) waitComplete(t *Torrent, cancel <-chan struct{}) error {
 	writeReport := func(f string, a ...interface{}) {
 		fmt.Fprintf(p.output, f, a...)
 	}
 
 	writeReport("%s: Getting torrent info\n", p.id)
-	<-t.tt.GotInfo()
+	select {
+	case <-t.tt.GotInfo():
+	case <-cancel:
+		return ErrDownloadCancelled
+	}
 	writeReport("%s: Start bittorent downloading\n", p.id)
 
 	p.bar.Start()
+	defer writeReport("\n")
+	// Stops the refresh of the bar
+	defer p.bar.Finish()
 	for {
-		total := t.tt.Info().TotalLength()
-		completed := t.tt.BytesCompleted()
+		completed, total := t.progress()
 		if completed >= total {
-			break
+			p.bar.Set(int(completed))
+			return nil
 		}
 		p.bar.Set(int(completed))
-		time.Sleep(500 * time.Millisecond)
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-cancel:
+			return ErrDownloadCancelled
+		}
 	}
-	writeReport("\n")
 }
 
 func NewProgressDownload(id string, size int, output io.Writer) *ProgressDownload {
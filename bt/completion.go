@@ -0,0 +1,202 @@
+package bt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+// completionDirName is the directory of the data directory holding the
+// pieces known complete of each torrent, so that they are not hashed again
+// after a restart
+const completionDirName = ".completion"
+
+// fileStamp identifies the content of a data file without reading it
+type fileStamp struct {
+	Size    int64 `json:"size"`
+	ModTime int64 `json:"mtime"`
+}
+
+// completionRecord is the completion of a torrent stored on disk. It only
+// applies while the data files keep the recorded stamps.
+type completionRecord struct {
+	Files  []fileStamp `json:"files"`
+	Pieces []bool      `json:"pieces"`
+}
+
+func (e *BtEngine) completionDir() string {
+	return path.Join(e.dataDir, completionDirName)
+}
+
+func (e *BtEngine) completionPath(infoHash string) string {
+	return path.Join(e.completionDir(), infoHash)
+}
+
+// completionStorage keeps the completion of the torrents stored by files
+// across restarts. files only has to keep it while the torrent is open.
+type completionStorage struct {
+	e     *BtEngine
+	files storage.Client
+}
+
+func (s *completionStorage) OpenTorrent(info *metainfo.InfoEx) (storage.Torrent, error) {
+	st, err := s.files.OpenTorrent(info)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &completionTorrent{
+		Torrent: st,
+		path:    s.e.completionPath(info.Hash().HexString()),
+		pieces:  make([]bool, info.NumPieces()),
+	}
+	for _, f := range info.UpvertedFiles() {
+		t.files = append(t.files, filepath.Join(append([]string{s.e.dataDir, info.Name}, f.Path...)...))
+	}
+	t.load()
+	return t, nil
+}
+
+type completionTorrent struct {
+	storage.Torrent
+	path  string
+	files []string
+
+	mu     sync.Mutex
+	pieces []bool
+	dirty  bool
+}
+
+// stamps returns the stamps of the data files, a missing file has a size
+// of -1
+func (t *completionTorrent) stamps() []fileStamp {
+	var stamps []fileStamp
+	for _, fn := range t.files {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			stamps = append(stamps, fileStamp{Size: -1})
+			continue
+		}
+		stamps = append(stamps, fileStamp{
+			Size:    fi.Size(),
+			ModTime: fi.ModTime().UnixNano(),
+		})
+	}
+	return stamps
+}
+
+// load reads the recorded completion, ignored if the data files changed
+// since it was saved
+func (t *completionTorrent) load() {
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return
+	}
+	var r completionRecord
+	if err = json.Unmarshal(data, &r); err != nil || len(r.Pieces) != len(t.pieces) {
+		log.Debugf("Ignore invalid completion %s", t.path)
+		return
+	}
+	stamps := t.stamps()
+	if len(r.Files) != len(stamps) {
+		return
+	}
+	for i := range stamps {
+		if r.Files[i] != stamps[i] {
+			log.Debugf("Ignore completion %s of modified files", t.path)
+			return
+		}
+	}
+	copy(t.pieces, r.Pieces)
+}
+
+// save records the completion with the current stamps of the data files.
+// Pieces written after it make the stamps differ and the record ignored.
+func (t *completionTorrent) save() {
+	if !t.dirty {
+		return
+	}
+	data, err := json.Marshal(&completionRecord{
+		Files:  t.stamps(),
+		Pieces: t.pieces,
+	})
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		log.Warnf("Create completion directory failed: %v", err)
+		return
+	}
+	tmp := t.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, t.path)
+	}
+	if err != nil {
+		log.Warnf("Save completion %s failed: %v", t.path, err)
+		return
+	}
+	t.dirty = false
+}
+
+func (t *completionTorrent) complete() bool {
+	for _, c := range t.pieces {
+		if !c {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *completionTorrent) Piece(p metainfo.Piece) storage.Piece {
+	return &completionPiece{
+		Piece: t.Torrent.Piece(p),
+		t:     t,
+		index: p.Index(),
+	}
+}
+
+func (t *completionTorrent) Close() error {
+	t.mu.Lock()
+	t.save()
+	t.mu.Unlock()
+	return t.Torrent.Close()
+}
+
+type completionPiece struct {
+	storage.Piece
+	t     *completionTorrent
+	index int
+}
+
+// MarkComplete saves the completion once all the pieces are complete, and
+// when the torrent is closed
+func (p *completionPiece) MarkComplete() error {
+	if err := p.Piece.MarkComplete(); err != nil {
+		return err
+	}
+	p.t.mu.Lock()
+	defer p.t.mu.Unlock()
+	if p.t.pieces[p.index] {
+		return nil
+	}
+	p.t.pieces[p.index] = true
+	p.t.dirty = true
+	if p.t.complete() {
+		p.t.save()
+	}
+	return nil
+}
+
+func (p *completionPiece) GetIsComplete() bool {
+	p.t.mu.Lock()
+	complete := p.t.pieces[p.index]
+	p.t.mu.Unlock()
+	return complete || p.Piece.GetIsComplete()
+}
@@ -0,0 +1,102 @@
+package bt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+func TestCompletionStorage(t *testing.T) {
+	root, err := ioutil.TempDir("", "bt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	e := NewBtEngine(root, nil, &Config{})
+	if err := os.MkdirAll(e.dataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("layer"), 1000)
+	id := fmt.Sprintf("%x", sha256.Sum256(content))
+	fn := e.GetFilePath(id)
+	if err := ioutil.WriteFile(fn, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := buildLayerInfo(id, fn, 1024, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &completionStorage{
+		e:     e,
+		files: storage.NewFileWithCompletion(e.dataDir, storage.PieceCompletionMap),
+	}
+
+	// The completion is saved once all the pieces are complete
+	ts, err := s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		if _, err := os.Stat(e.completionPath(info.Hash().HexString())); err == nil {
+			t.Fatalf("piece %d: completion saved before the last piece", i)
+		}
+		if err := ts.Piece(info.Piece(i)).MarkComplete(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ts.Close()
+
+	ts, err = s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		if !ts.Piece(info.Piece(i)).GetIsComplete() {
+			t.Errorf("piece %d: expected complete after reopening", i)
+		}
+	}
+	ts.Close()
+
+	// A modified file is hashed again
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(fn, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	ts, err = s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		if ts.Piece(info.Piece(i)).GetIsComplete() {
+			t.Errorf("piece %d: expected incomplete after modification", i)
+		}
+	}
+	ts.Close()
+
+	// Partial completion is saved when the torrent is closed
+	ts, err = s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Piece(info.Piece(1)).MarkComplete(); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	ts, err = s.OpenTorrent(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < info.NumPieces(); i++ {
+		if complete := ts.Piece(info.Piece(i)).GetIsComplete(); complete != (i == 1) {
+			t.Errorf("piece %d: expected complete %v, got %v", i, i == 1, complete)
+		}
+	}
+	ts.Close()
+}
@@ -6,6 +6,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -16,32 +18,109 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/dht"
 	"github.com/anacrolix/torrent/metainfo"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
 )
 
 var (
-	ErrBtEngineNotStart = fmt.Errorf("BT engine not started")
-	ErrIdNotExist       = fmt.Errorf("ID not exist")
+	ErrBtEngineNotStart  = fmt.Errorf("BT engine not started")
+	ErrIdNotExist        = fmt.Errorf("ID not exist")
+	ErrDownloadCancelled = fmt.Errorf("Download cancelled")
 )
 
 const DefaultUploadRateLimit = 50 * 1024 * 1024 // 50Mb/s
 const DefaultDownloadRateLimit = 50 * 1024 * 1024
 
+const dhtRefreshInterval = 30 * time.Second
+
+// DefaultLoadConcurrency is the number of torrents loaded, and hashed if
+// needed, at once at startup
+const DefaultLoadConcurrency = 4
+
+// Leechers give up when no peer sent the info dictionary of a magnet
+// link within infoTimeout
+const infoTimeout = 2 * time.Minute
+
 type Config struct {
 	DisableEncryption bool
+	RequireEncryption bool
+	DisableTCP        bool
+	DisableUTP        bool
 	EnableUpload      bool
 	EnableSeeding     bool
-	IncomingPort      int
+	// Piece length of the created torrents. If 0, it is the smallest power
+	// of two between MinPieceLength and MaxPieceLength giving at most
+	// TargetPieces pieces, or the defaults if unset. All nodes must use the
+	// same values to share swarms.
+	PieceLength    int64
+	MinPieceLength int64
+	MaxPieceLength int64
+	TargetPieces   int64
+
+	// Listen on ListenHost, an IP address or an interface name, and on the
+	// first free port between IncomingPort and MaxPort
+	ListenHost   string
+	IncomingPort int
+	MaxPort      int
+
 	UploadRateLimit   int
 	DownloadRateLimit int
+
+	// Private DHT, bootstrapped only from DHTNodes (host:port), never from
+	// the public DHT routers
+	EnableDHT bool
+	DHTNodes  []string
+
+	// Local Service Discovery on LSDInterfaces, or on the default
+	// multicast interface if empty
+	EnableLSD     bool
+	LSDInterfaces []string
+
+	// Topology labels, peers in the same rack then in the same zone are
+	// preferred. Traffic with other zones is limited by the cross zone
+	// rates on top of the global ones.
+	Zone                       string
+	Rack                       string
+	CrossZoneUploadRateLimit   int
+	CrossZoneDownloadRateLimit int
+
+	// Create private torrents, only found through trackers and seeders.
+	// Peers must prove PeerSecret if set, and connect from AllowedPeers
+	// subnets if any.
+	Private      bool
+	PeerSecret   string
+	AllowedPeers []string
+
+	// Web seeds (BEP 19) embedded in the created torrents, leechers fetch
+	// the pieces the swarm cannot supply from them under WebSeedRateLimit
+	WebSeeds         []string
+	WebSeedRateLimit int
+
+	// The completion of the pieces is kept across restarts, unless
+	// VerifyOnStart hashes them again. The torrents of the data directory
+	// are loaded by LoadConcurrency workers, DefaultLoadConcurrency if 0.
+	VerifyOnStart   bool
+	LoadConcurrency int
 }
 
 type Status struct {
-	Id        string `json:"id"`
-	State     string `json:"state"`
-	Completed int64  `json:"completed"`
-	TotalLen  int64  `json:"totallength"`
-	Seeding   bool   `json:"seeding"`
+	Id           string  `json:"id"`
+	State        string  `json:"state"`
+	Completed    int64   `json:"completed"`
+	TotalLen     int64   `json:"totallength"`
+	Seeding      bool    `json:"seeding"`
+	Uploaded     int64   `json:"uploaded"`
+	DownloadRate float32 `json:"downloadrate"`
+	Peers        int     `json:"peers"`
+	KnownPeers   int     `json:"knownpeers"`
+}
+
+// RateLimit is a pair of rate limits in bytes per second, 0 means unlimited
+type RateLimit struct {
+	Upload   int `json:"upload"`
+	Download int `json:"download"`
 }
 
 type idInfo struct {
@@ -59,13 +138,27 @@ type BtEngine struct {
 	config *Config
 	ts     map[string]*Torrent // InfoHash -> torrent
 
-	idInfos  map[string]*idInfo // image ID -> InfoHash
+	idInfos  map[string]*idInfo    // image ID -> InfoHash
+	limits   map[string]RateLimit  // image ID -> per torrent rate limit
+	groups   map[string]*rateGroup // group name -> rate limit shared by torrents
 	rootDir  string
 	trackers []string
 
 	torrentDir string
 	dataDir    string
 
+	lsd *lsd
+
+	// Layers stored as blobs of OCI directories
+	blobMut sync.Mutex
+	blobs   map[string]*layerBlob            // layer ID -> blob
+	images  map[string]map[string]*layerBlob // image ID -> digest -> blob
+
+	downloads map[string]*download // ID -> leech in progress
+
+	webSeedClient  *http.Client
+	webSeedLimiter *rate.Limiter
+
 	started bool
 }
 
@@ -75,6 +168,7 @@ func NewBtEngine(root string, trackers []string, c *Config) *BtEngine {
 	if c == nil {
 		c = &Config{
 			DisableEncryption: true,
+			DisableUTP:        true,
 			EnableUpload:      true,
 			EnableSeeding:     true,
 			IncomingPort:      50007,
@@ -90,6 +184,14 @@ func NewBtEngine(root string, trackers []string, c *Config) *BtEngine {
 		config:     c,
 		ts:         map[string]*Torrent{},
 		idInfos:    map[string]*idInfo{},
+		limits:     map[string]RateLimit{},
+		groups:     map[string]*rateGroup{},
+		blobs:      map[string]*layerBlob{},
+		images:     map[string]map[string]*layerBlob{},
+		downloads:  map[string]*download{},
+
+		webSeedClient:  &http.Client{Timeout: 5 * time.Minute},
+		webSeedLimiter: newWebSeedLimiter(c.WebSeedRateLimit),
 	}
 }
 
@@ -105,35 +207,120 @@ func (e *BtEngine) Run() error {
 		e.client.Close()
 		time.Sleep(1 * time.Second)
 	}
+	if e.lsd != nil {
+		e.lsd.close()
+		e.lsd = nil
+	}
 
 	c := e.config
 	if c.IncomingPort <= 0 {
 		return fmt.Errorf("Invalid incoming port (%d)", c.IncomingPort)
 	}
+	if c.DisableTCP && c.DisableUTP {
+		return fmt.Errorf("TCP and uTP cannot be both disabled")
+	}
+	maxPort := c.MaxPort
+	if maxPort < c.IncomingPort {
+		maxPort = c.IncomingPort
+	}
+	host, err := resolveListenHost(c.ListenHost)
+	if err != nil {
+		return err
+	}
 	tc := torrent.Config{
 		DataDir:           e.dataDir,
-		ListenAddr:        "0.0.0.0:" + strconv.Itoa(c.IncomingPort),
 		NoUpload:          !c.EnableUpload,
 		Seed:              c.EnableSeeding,
 		DisableEncryption: c.DisableEncryption,
-		DisableUTP:        true,
+		ForceEncryption:   c.RequireEncryption,
+		DisableTCP:        c.DisableTCP,
+		DisableUTP:        c.DisableUTP,
+		UploadRateLimit:   c.UploadRateLimit,
+		DownloadRateLimit: c.DownloadRateLimit,
+		NoDHT:             !c.EnableDHT,
+		DHTConfig: dht.ServerConfig{
+			BootstrapNodes:     c.DHTNodes,
+			NoDefaultBootstrap: true,
+		},
+		Zone:                       c.Zone,
+		Rack:                       c.Rack,
+		CrossZoneUploadRateLimit:   c.CrossZoneUploadRateLimit,
+		CrossZoneDownloadRateLimit: c.CrossZoneDownloadRateLimit,
+		PeerSecret:                 c.PeerSecret,
+	}
+	tc.DefaultStorage = newBlobStorage(e)
+	if len(c.AllowedPeers) > 0 {
+		allowed, err := ParseAllowedPeers(c.AllowedPeers)
+		if err != nil {
+			return err
+		}
+		tc.IPBlocklist = allowed
+	}
+	var client *torrent.Client
+	for port := c.IncomingPort; port <= maxPort; port++ {
+		tc.ListenAddr = net.JoinHostPort(host, strconv.Itoa(port))
+		client, err = torrent.NewClient(&tc)
+		if err == nil {
+			break
+		}
+		log.Warnf("Listen on %s failed: %v", tc.ListenAddr, err)
 	}
-	client, err := torrent.NewClient(&tc)
 	if err != nil {
-		return err
+		return fmt.Errorf("Listen on ports %d-%d failed: %v", c.IncomingPort, maxPort, err)
 	}
+	log.Infof("Bittorrent listening on %s, encryption %s", client.ListenAddr(), encryptionMode(c))
 
 	e.client = client
+	if c.EnableDHT {
+		go e.refreshDHT(client)
+	}
+	if c.EnableLSD {
+		_, port, _ := net.SplitHostPort(client.ListenAddr().String())
+		p, _ := strconv.Atoi(port)
+		l, err := newLSD(p, c.LSDInterfaces)
+		if err != nil {
+			return fmt.Errorf("Start local service discovery failed: %v", err)
+		}
+		e.lsd = l
+		l.serve(e.addLSDPeer)
+		go e.announceLSD(l)
+	}
 
 	// for StartSeed
 	e.started = true
 
+	if c.VerifyOnStart {
+		// Hash all the pieces again instead of trusting their completion
+		if err = os.RemoveAll(e.completionDir()); err != nil {
+			return fmt.Errorf("Remove piece completion failed: %v", err)
+		}
+	}
+
 	files, err := ioutil.ReadDir(e.dataDir)
 	if err != nil {
 		return err
 	}
 
+	var jobs []loadJob
 	for _, f := range files {
+		if filepath.Ext(f.Name()) == ".blob" {
+			// Layers stored in OCI directories are seeded from their blob
+			id := strings.TrimSuffix(f.Name(), ".blob")
+			if !e.loadBlob(id) {
+				continue
+			}
+			jobs = append(jobs, loadJob{id, e.StartSeed})
+			continue
+		}
+		if filepath.Ext(f.Name()) == ".image" {
+			// Image torrents are seeded again with the blobs they hold
+			id := strings.TrimSuffix(f.Name(), ".image")
+			if _, err = os.Lstat(e.GetTorrentFilePath(id)); err != nil {
+				continue
+			}
+			jobs = append(jobs, loadJob{id, e.startImage})
+			continue
+		}
 		if filepath.Ext(f.Name()) != ".layer" {
 			continue
 		}
@@ -146,17 +333,192 @@ func (e *BtEngine) Run() error {
 		id := ss[0]
 		tf := e.GetTorrentFilePath(id)
 		if _, err = os.Lstat(tf); err != nil {
+			jobs = append(jobs, loadJob{id, e.seedDownloadedLayer})
 			continue
 		}
+		jobs = append(jobs, loadJob{id, e.StartSeed})
+	}
+	go e.loadTorrents(jobs)
+
+	return nil
+}
+
+// loadJob starts the torrent of id found in the data directory at startup
+type loadJob struct {
+	id    string
+	start func(id string) error
+}
+
+// loadTorrents runs jobs by LoadConcurrency workers. A worker waits for the
+// pieces of its torrent to be checked before starting the next one, so that
+// at most LoadConcurrency torrents are hashed at once.
+func (e *BtEngine) loadTorrents(jobs []loadJob) {
+	n := e.config.LoadConcurrency
+	if n <= 0 {
+		n = DefaultLoadConcurrency
+	}
+	ch := make(chan loadJob)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				if err := job.start(job.id); err != nil {
+					log.Errorf("Start seed %s failed: %v", job.id, err)
+					continue
+				}
+				if t, err := e.torrentOf(job.id); err == nil {
+					t.waitChecked()
+				}
+			}
+		}()
+	}
+
+	start := time.Now()
+	for _, job := range jobs {
+		ch <- job
+	}
+	close(ch)
+	wg.Wait()
+	log.Infof("Loaded %d torrents in %v", len(jobs), time.Since(start))
+}
+
+// torrentOf returns the torrent of id
+func (e *BtEngine) torrentOf(id string) (*Torrent, error) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	info, ok := e.idInfos[id]
+	if !ok {
+		return nil, ErrIdNotExist
+	}
+	return e.getTorrent(info.InfoHash)
+}
+
+// seedDownloadedLayer seeds a layer downloaded before a restart, whose
+// torrent is created again as the seeders created it. Incomplete downloads
+// are skipped.
+func (e *BtEngine) seedDownloadedLayer(id string) error {
+	if err := VerifyLayerFile(e.GetFilePath(id), layerDigest(id)); err != nil {
+		log.Debugf("Skip seeding layer %s: %v", id, err)
+		return nil
+	}
+	return e.StartSeed(id)
+}
+
+// refreshDHT adds the bootstrap nodes again whenever the DHT of client has
+// no node left, e.g. when all seeders were down at startup
+func (e *BtEngine) refreshDHT(client *torrent.Client) {
+	for {
+		time.Sleep(dhtRefreshInterval)
+
+		e.mut.Lock()
+		if e.client != client {
+			e.mut.Unlock()
+			return
+		}
+		nodes := e.config.DHTNodes
+		e.mut.Unlock()
+
+		if client.DHT().Stats().Nodes == 0 {
+			e.AddDHTNodes(nodes)
+		}
+	}
+}
+
+// SetDHTNodes replaces the bootstrap nodes of the private DHT and adds
+// them to the running DHT
+func (e *BtEngine) SetDHTNodes(nodes []string) {
+	e.mut.Lock()
+	e.config.DHTNodes = nodes
+	e.mut.Unlock()
+	e.AddDHTNodes(nodes)
+}
 
-		if err = e.StartSeed(id); err != nil {
-			log.Errorf("Start seed %s failed: %v", id, err)
+// announceLSD periodically announces all torrents on the local network
+// until l is closed
+func (e *BtEngine) announceLSD(l *lsd) {
+	for !l.isClosed() {
+		e.mut.Lock()
+		var ihs []string
+		for ih, t := range e.ts {
+			if !isPrivate(t.tt) {
+				ihs = append(ihs, ih)
+			}
 		}
+		e.mut.Unlock()
+
+		l.announce(ihs)
+		time.Sleep(lsdAnnounceInterval)
 	}
+}
 
+// addLSDPeer adds a peer found on the local network to the torrent of
+// infoHash, if any
+func (e *BtEngine) addLSDPeer(infoHash string, addr *net.TCPAddr) {
+	e.mut.Lock()
+	t, ok := e.ts[infoHash]
+	e.mut.Unlock()
+	if !ok || isPrivate(t.tt) {
+		return
+	}
+	log.Debugf("Found LSD peer %s for %s", addr, infoHash)
+	t.tt.AddPeers([]torrent.Peer{{
+		IP:   addr.IP,
+		Port: addr.Port,
+	}})
+}
+
+// SetAllowedPeers only accepts peers from subnets, or from everywhere if
+// empty. Connected peers are kept.
+func (e *BtEngine) SetAllowedPeers(subnets []string) error {
+	if !e.started {
+		return ErrBtEngineNotStart
+	}
+	if len(subnets) == 0 {
+		e.client.SetIPBlockList(nil)
+		return nil
+	}
+	allowed, err := ParseAllowedPeers(subnets)
+	if err != nil {
+		return err
+	}
+	e.client.SetIPBlockList(allowed)
 	return nil
 }
 
+// isPrivate returns true for private torrents (BEP 27)
+func isPrivate(tt *torrent.Torrent) bool {
+	info := tt.Info()
+	return info != nil && info.Private != nil && *info.Private
+}
+
+// AddDHTNodes adds nodes, of the form host:port, to the private DHT
+func (e *BtEngine) AddDHTNodes(nodes []string) {
+	if !e.started || !e.config.EnableDHT {
+		return
+	}
+
+	var addrs []string
+	for _, n := range nodes {
+		addr, err := net.ResolveUDPAddr("udp4", n)
+		if err != nil {
+			log.Warnf("Resolve DHT node %s failed: %v", n, err)
+			continue
+		}
+		addrs = append(addrs, addr.String())
+	}
+	e.client.AddDHTNodes(addrs)
+}
+
+// DHTNodes returns the number of nodes known to the private DHT
+func (e *BtEngine) DHTNodes() int {
+	if !e.started || !e.config.EnableDHT {
+		return 0
+	}
+	return e.client.DHT().Stats().Nodes
+}
+
 func (e *BtEngine) Started() bool {
 	e.mut.Lock()
 	defer e.mut.Unlock()
@@ -171,8 +533,12 @@ func (e *BtEngine) GetTorrentFilePath(id string) string {
 	return path.Join(e.rootDir, "torrents", id+".torrent")
 }
 
+// GetFilePath returns the file of layer id, its blob if it has one
 func (e *BtEngine) GetFilePath(id string) string {
-	return path.Join(e.rootDir, "data", id+".layer")
+	if b := e.getBlob(id); b != nil {
+		return b.path
+	}
+	return path.Join(e.dataDir, layerFileName(id))
 }
 
 func (e *BtEngine) GetTorrent(id string) ([]byte, error) {
@@ -182,6 +548,9 @@ func (e *BtEngine) GetTorrent(id string) ([]byte, error) {
 		return nil, ErrBtEngineNotStart
 	}
 
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
 	info, ok := e.idInfos[id]
 	if !ok {
 		return nil, fmt.Errorf("Get torrent for %s not founded", id)
@@ -189,10 +558,17 @@ func (e *BtEngine) GetTorrent(id string) ([]byte, error) {
 
 	t, err := e.getTorrent(info.InfoHash)
 	if err != nil {
-		return nil, fmt.Errorf("Get torrent for %s failed: %v", err)
+		return nil, fmt.Errorf("Get torrent for %s failed: %v", id, err)
 	}
 
 	m := t.tt.Metainfo()
+	// Leave out the fields that differ between nodes
+	m.CreationDate, m.Comment, m.CreatedBy = 0, "", ""
+	// The metainfo of the client has no web seeds, they only serve the
+	// files of layer torrents
+	if len(e.config.WebSeeds) > 0 && len(m.Info.Files) == 0 {
+		m.URLList = e.config.WebSeeds
+	}
 	w := bufio.NewWriter(&b)
 	if err = m.Write(w); err != nil {
 		return nil, fmt.Errorf("Write metainfo %s error: %v", id, err)
@@ -200,11 +576,77 @@ func (e *BtEngine) GetTorrent(id string) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// ListenAddr returns the address accepting bittorrent connections
+func (e *BtEngine) ListenAddr() string {
+	if !e.started {
+		return ""
+	}
+	addr := e.client.ListenAddr()
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// GetPeers returns the HOST:PORT of up to max peers connected to the
+// torrent of id
+func (e *BtEngine) GetPeers(id string, max int) ([]string, error) {
+	if !e.started {
+		return nil, ErrBtEngineNotStart
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	info, ok := e.idInfos[id]
+	if !ok {
+		return nil, fmt.Errorf("Get torrent for %s not founded", id)
+	}
+
+	t, err := e.getTorrent(info.InfoHash)
+	if err != nil {
+		return nil, fmt.Errorf("Get torrent for %s failed: %v", id, err)
+	}
+
+	var peers []string
+	for _, p := range t.tt.ConnectedPeers(max) {
+		peers = append(peers, net.JoinHostPort(p.IP.String(), strconv.Itoa(p.Port)))
+	}
+	return peers, nil
+}
+
+// parsePeers converts HOST:PORT addresses to peers, hosts must be IP
+// addresses
+func parsePeers(addrs []string) []torrent.Peer {
+	var peers []torrent.Peer
+	for _, addr := range addrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			log.Warnf("Invalid peer address %s: %v", addr, err)
+			continue
+		}
+		ip := net.ParseIP(host)
+		p, err := strconv.Atoi(port)
+		if ip == nil || err != nil || p <= 0 || p > 65535 {
+			log.Warnf("Invalid peer address %s", addr)
+			continue
+		}
+		peers = append(peers, torrent.Peer{
+			IP:   ip,
+			Port: p,
+		})
+	}
+	return peers
+}
+
 func (e *BtEngine) GetStatus(id string) (*Status, error) {
 	if !e.started {
 		return nil, ErrBtEngineNotStart
 	}
 
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
 	info, ok := e.idInfos[id]
 	if !ok {
 		return nil, ErrIdNotExist
@@ -216,13 +658,7 @@ func (e *BtEngine) GetStatus(id string) (*Status, error) {
 	}
 
 	t.Update()
-	return &Status{
-		Id:        id,
-		State:     t.State.String(),
-		Completed: t.Downloaded,
-		TotalLen:  t.Size,
-		Seeding:   t.Seeding,
-	}, nil
+	return t.status(id), nil
 }
 
 func (e *BtEngine) GetAllStatus() ([]Status, error) {
@@ -242,18 +678,143 @@ func (e *BtEngine) GetAllStatus() ([]Status, error) {
 		}
 
 		t.Update()
-		ss = append(ss, Status{
-			Id:        id,
-			State:     t.State.String(),
-			Completed: t.Downloaded,
-			TotalLen:  t.Size,
-			Seeding:   t.Seeding,
-		})
+		ss = append(ss, *t.status(id))
 	}
 	log.Debugf("All status: %v", ss)
 	return ss, nil
 }
 
+// SetTorrentRateLimit caps the rates of the torrent of id on top of the
+// global limits, the cap is kept if the torrent is started later
+func (e *BtEngine) SetTorrentRateLimit(id string, limit RateLimit) error {
+	if limit.Upload < 0 || limit.Download < 0 {
+		return fmt.Errorf("Invalid rate limit %+v", limit)
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if limit.Upload == 0 && limit.Download == 0 {
+		delete(e.limits, id)
+	} else {
+		e.limits[id] = limit
+	}
+	if info, ok := e.idInfos[id]; ok {
+		if t, err := e.getTorrent(info.InfoHash); err == nil {
+			t.tt.SetRateLimits(limit.Upload, limit.Download)
+		}
+	}
+	return nil
+}
+
+// GetTorrentRateLimit returns the cap of the torrent of id
+func (e *BtEngine) GetTorrentRateLimit(id string) RateLimit {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.limits[id]
+}
+
+// rateGroup is a rate limit shared by the torrents of ids
+type rateGroup struct {
+	limit RateLimit
+	tg    *torrent.RateLimitGroup
+	ids   map[string]bool
+}
+
+// SetGroupRateLimit caps the summed rates of the torrents of the group
+// name on top of their own caps, 0 removes the cap
+func (e *BtEngine) SetGroupRateLimit(name string, limit RateLimit) error {
+	if limit.Upload < 0 || limit.Download < 0 {
+		return fmt.Errorf("Invalid rate limit %+v", limit)
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	g := e.group(name)
+	if err := g.tg.SetRateLimits(limit.Upload, limit.Download); err != nil {
+		return err
+	}
+	g.limit = limit
+	e.pruneGroup(name)
+	return nil
+}
+
+// GetGroupRateLimit returns the cap of the group name
+func (e *BtEngine) GetGroupRateLimit(name string) RateLimit {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if g, ok := e.groups[name]; ok {
+		return g.limit
+	}
+	return RateLimit{}
+}
+
+// AddGroupTorrent adds the torrent of id to the group name, the torrent
+// may be added later. A torrent in several groups is capped by each of
+// them. It is dropped from its groups when deleted.
+func (e *BtEngine) AddGroupTorrent(name, id string) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	g := e.group(name)
+	if g.ids[id] {
+		return
+	}
+	g.ids[id] = true
+	if info, ok := e.idInfos[id]; ok {
+		if t, err := e.getTorrent(info.InfoHash); err == nil {
+			t.tt.SetRateLimitGroups(e.torrentGroups(id)...)
+		}
+	}
+}
+
+// group returns the group name, creating it without cap, e.mut must be
+// held
+func (e *BtEngine) group(name string) *rateGroup {
+	g, ok := e.groups[name]
+	if !ok {
+		g = &rateGroup{
+			tg:  torrent.NewRateLimitGroup(0, 0),
+			ids: map[string]bool{},
+		}
+		e.groups[name] = g
+	}
+	return g
+}
+
+// pruneGroup forgets the group name if it has neither cap nor torrent,
+// e.mut must be held
+func (e *BtEngine) pruneGroup(name string) {
+	g := e.groups[name]
+	if g.limit.Upload == 0 && g.limit.Download == 0 && len(g.ids) == 0 {
+		delete(e.groups, name)
+	}
+}
+
+// torrentGroups returns the groups of the torrent of id, e.mut must be
+// held
+func (e *BtEngine) torrentGroups(id string) []*torrent.RateLimitGroup {
+	var tgs []*torrent.RateLimitGroup
+	for _, g := range e.groups {
+		if g.ids[id] {
+			tgs = append(tgs, g.tg)
+		}
+	}
+	return tgs
+}
+
+// removeGroupTorrent drops the torrent of id from its groups, e.mut must
+// be held
+func (e *BtEngine) removeGroupTorrent(id string) {
+	for name, g := range e.groups {
+		if g.ids[id] {
+			delete(g.ids, id)
+			e.pruneGroup(name)
+		}
+	}
+}
+
 func (e *BtEngine) SetUploadRateLimit(upload int) error {
 	if !e.started {
 		return ErrBtEngineNotStart
@@ -261,6 +822,15 @@ func (e *BtEngine) SetUploadRateLimit(upload int) error {
 	return e.client.SetUploadRateLimit(upload)
 }
 
+// SetCrossZoneRateLimit sets the caps of the traffic with peers of other
+// zones, 0 means unlimited
+func (e *BtEngine) SetCrossZoneRateLimit(limit RateLimit) error {
+	if !e.started {
+		return ErrBtEngineNotStart
+	}
+	return e.client.SetCrossZoneRateLimits(limit.Upload, limit.Download)
+}
+
 func (e *BtEngine) GetUploadRateLimit() (int, error) {
 	if !e.started {
 		return -1, ErrBtEngineNotStart
@@ -282,6 +852,25 @@ func (e *BtEngine) GetDownloadRateLimit() (int, error) {
 	return e.client.GetDownloadRateLimit()
 }
 
+// SetTrackers replaces the trackers used by new torrents and by the running
+// ones, active torrents are not interrupted.
+func (e *BtEngine) SetTrackers(trackers []string) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	e.trackers = trackers
+	if !e.started {
+		return
+	}
+	var announceList [][]string
+	if len(trackers) > 0 {
+		announceList = [][]string{trackers}
+	}
+	for _, t := range e.ts {
+		t.tt.SetTrackers(announceList)
+	}
+}
+
 func (e *BtEngine) StartSeed(id string) error {
 	if !e.started {
 		return ErrBtEngineNotStart
@@ -310,12 +899,45 @@ func (e *BtEngine) StartSeed(id string) error {
 		return fmt.Errorf("Load torrent file failed: %v", err)
 	}
 
+	// Torrent files created by older versions are not bound to the layer
+	// digest, recreate them, as well as those whose private flag or piece
+	// length differ from the ones every other node would use.
+	if d, err := parseLayerInfo(metaInfo); err != nil || d.Digest != layerDigest(id) ||
+		d.Private != e.config.Private || d.PieceLength != e.pieceLength(d.Length) {
+		if err == nil && d.PieceLength != e.pieceLength(d.Length) {
+			log.Warnf("Torrent file of %s has piece length %d, this node uses %d: "+
+				"recreating it changes its infohash, the piece length settings must "+
+				"be the same on all nodes or they end up in separate swarms",
+				id, d.PieceLength, e.pieceLength(d.Length))
+		}
+		log.Infof("Recreate torrent file for %s", id)
+		if err = e.createTorrent(id); err != nil {
+			return err
+		}
+		if metaInfo, err = metainfo.LoadFromFile(tf); err != nil {
+			return fmt.Errorf("Load torrent file failed: %v", err)
+		}
+	}
+
+	return e.addSeed(id, metaInfo)
+}
+
+// addSeed adds the torrent of id and starts it once its pieces are
+// verified, e.mut must be held
+func (e *BtEngine) addSeed(id string, metaInfo *metainfo.MetaInfo) error {
 	tt, err := e.client.AddTorrent(metaInfo)
 	if err != nil {
 		return fmt.Errorf("Add torrent failed: %v", err)
 	}
 
 	t := e.addTorrent(tt)
+	if limit, ok := e.limits[id]; ok {
+		tt.SetRateLimits(limit.Upload, limit.Download)
+	}
+	tt.SetRateLimitGroups(e.torrentGroups(id)...)
+	if e.lsd != nil && !isPrivate(tt) {
+		go e.lsd.announce([]string{t.InfoHash})
+	}
 	go func() {
 		<-t.tt.GotInfo()
 		err = e.startTorrent(t.InfoHash)
@@ -361,61 +983,271 @@ func (e *BtEngine) StopTorrent(id string) error {
 	return nil
 }
 
-func (e *BtEngine) StartLeecher(id string, torrentData []byte, p *ProgressDownload) error {
+// StartLeecher downloads the torrent of id, peers are HOST:PORT addresses
+// of known peers added to those found by trackers, DHT or LSD. It returns
+// when ctx is done, the download is cancelled unless another leecher waits
+// for it.
+func (e *BtEngine) StartLeecher(ctx context.Context, id string, torrentData []byte, peers []string, p *ProgressDownload) error {
 	if !e.started {
 		return ErrBtEngineNotStart
 	}
 
+	// Load torrent data
+	reader := bytes.NewBuffer(torrentData)
+	metaInfo, err := metainfo.Load(reader)
+	if err != nil {
+		return fmt.Errorf("Load torrent file failed: %v", err)
+	}
+
+	add := func() (*torrent.Torrent, error) {
+		return e.client.AddTorrent(metaInfo)
+	}
+	return e.leech(ctx, id, add, peers, webSeedURLs(metaInfo), nil, nil, p)
+}
+
+// StartMagnetLeecher downloads the torrent of id from its magnet URI. The
+// info dictionary is fetched from the peers (BEP 9), and nothing is
+// downloaded unless validate accepts the torrent data built from it.
+func (e *BtEngine) StartMagnetLeecher(ctx context.Context, id, magnetURI string, peers []string, validate func(torrentData []byte) error, p *ProgressDownload) error {
+	if !e.started {
+		return ErrBtEngineNotStart
+	}
+
+	m, err := metainfo.ParseMagnetURI(magnetURI)
+	if err != nil {
+		return fmt.Errorf("Parse magnet failed: %v", err)
+	}
+	add := func() (*torrent.Torrent, error) {
+		return e.client.AddMagnet(magnetURI)
+	}
+	// The info dictionary fetched from peers has no web seeds, they come
+	// from the ws parameters
+	return e.leech(ctx, id, add, peers, m.WebSeeds, validate, nil, p)
+}
+
+// download is a leech in progress, the later leechers of the same torrent
+// wait for it and get its result
+type download struct {
+	done chan struct{}
+	err  error
+
+	// Leechers waiting for the download, it is cancelled when all of
+	// them gave up
+	waiters int
+	cancel  chan struct{}
+}
+
+// leech adds the torrent returned by add as the torrent of id, starts it
+// once its info is known and accepted by validate, and waits until the
+// wanted files, or all of them if nil, are downloaded or ctx is done
+func (e *BtEngine) leech(ctx context.Context, id string, add func() (*torrent.Torrent, error), peers, webSeeds []string, validate func([]byte) error, wanted map[string]bool, p *ProgressDownload) error {
 	e.mut.Lock()
 
 	info, ok := e.idInfos[id]
 	if ok && info.Started {
 		info.Count++
+		d := e.downloads[id]
+		if d == nil {
+			e.mut.Unlock()
+			return nil
+		}
+		d.waiters++
 		e.mut.Unlock()
-		return nil
-	}
-
-	// Load torrent data
-	reader := bytes.NewBuffer(torrentData)
-	metaInfo, err := metainfo.Load(reader)
-	if err != nil {
-		e.mut.Unlock()
-		return fmt.Errorf("Load torrent file failed: %v", err)
+		log.Debugf("Join bt download %s in progress", id)
+		return e.waitLeech(ctx, id, d)
 	}
 
-	tt, err := e.client.AddTorrent(metaInfo)
+	tt, err := add()
 	if err != nil {
 		e.mut.Unlock()
 		return fmt.Errorf("Add torrent failed: %v", err)
 	}
 
 	t := e.addTorrent(tt)
-	go func() {
-		<-t.tt.GotInfo()
-		err = e.startTorrent(t.InfoHash)
-		if err != nil {
-			log.Errorf("start torrent %v failed: %v", t.InfoHash, err)
-		} else {
-			log.Infof("start torrent %v success", t.InfoHash)
-		}
-	}()
+	t.wanted = wanted
+	if limit, ok := e.limits[id]; ok {
+		tt.SetRateLimits(limit.Upload, limit.Download)
+	}
+	tt.SetRateLimitGroups(e.torrentGroups(id)...)
+	if pp := parsePeers(peers); len(pp) > 0 {
+		tt.AddPeers(pp)
+	}
+	if len(webSeeds) > 0 {
+		go e.webSeed(t, webSeeds)
+	}
 
 	e.idInfos[id] = &idInfo{
 		Id:       id,
 		InfoHash: t.InfoHash,
 		Started:  true,
 	}
+	d := &download{
+		done:    make(chan struct{}),
+		waiters: 1,
+		cancel:  make(chan struct{}),
+	}
+	e.downloads[id] = d
 
 	e.mut.Unlock()
 
+	go func() {
+		err := e.waitDownload(id, t, validate, d.cancel, p)
+		e.mut.Lock()
+		d.err = err
+		// A failed download may already be replaced by a new one
+		if e.downloads[id] == d {
+			delete(e.downloads, id)
+		}
+		close(d.done)
+		e.mut.Unlock()
+	}()
+	return e.waitLeech(ctx, id, d)
+}
+
+// waitLeech waits for the download d of id until ctx is done. The leecher
+// giving up releases the torrent, and the last one cancels the download.
+func (e *BtEngine) waitLeech(ctx context.Context, id string, d *download) error {
+	select {
+	case <-d.done:
+		return d.err
+	case <-ctx.Done():
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	select {
+	case <-d.done:
+		return d.err
+	default:
+	}
+	d.waiters--
+	if d.waiters > 0 {
+		if info, ok := e.idInfos[id]; ok {
+			info.Count--
+		}
+		log.Infof("Leave bt download %s still needed by %d leechers", id, d.waiters)
+	} else {
+		log.Infof("Cancel bt download %s: %v", id, ctx.Err())
+		close(d.cancel)
+	}
+	return ctx.Err()
+}
+
+// waitDownload starts the torrent t of id once its info is accepted by
+// validate and waits until it is downloaded. The torrent is deleted if it
+// fails or cancel is closed first.
+func (e *BtEngine) waitDownload(id string, t *Torrent, validate func([]byte) error, cancel <-chan struct{}, p *ProgressDownload) error {
+	if err := e.waitInfo(id, t, validate, cancel); err != nil {
+		e.dropLeech(id)
+		return err
+	}
+	if e.lsd != nil && !isPrivate(t.tt) {
+		go e.lsd.announce([]string{t.InfoHash})
+	}
+	if err := e.startTorrent(t.InfoHash); err != nil {
+		log.Errorf("start torrent %v failed: %v", t.InfoHash, err)
+	} else {
+		log.Infof("start torrent %v success", t.InfoHash)
+	}
+
+	log.Debugf("Waiting bt download %s complete", id)
+	var err error
 	if p != nil {
-		log.Debugf("Waiting bt download %s complete", id)
-		p.waitComplete(t)
-		log.Infof("Bt download %s completed", id)
+		err = p.waitComplete(t, cancel)
+	} else {
+		err = t.waitComplete(cancel)
+	}
+	if err != nil {
+		e.dropLeech(id)
+		return err
 	}
+	log.Infof("Bt download %s completed", id)
 	return nil
 }
 
+// dropLeech stops and deletes the torrent of id, with its partial data,
+// at once so that no leecher joins it in between. The leechers waiting for
+// it get the error of the download.
+func (e *BtEngine) dropLeech(id string) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if info, ok := e.idInfos[id]; ok && info.Started {
+		if err := e.stopTorrent(info.InfoHash); err != nil {
+			log.Errorf("Stop torrent %s failed: %v", id, err)
+		}
+		info.Started = false
+		info.Count = 0
+	}
+	if err := e.removeTorrent(id); err != nil {
+		log.Errorf("Delete torrent %s failed: %v", id, err)
+	}
+}
+
+// waitInfo waits for the info dictionary of the torrent of id and checks
+// the torrent data with validate
+func (e *BtEngine) waitInfo(id string, t *Torrent, validate func([]byte) error, cancel <-chan struct{}) error {
+	select {
+	case <-t.tt.GotInfo():
+	case <-time.After(infoTimeout):
+		return fmt.Errorf("Get torrent info of %s from peers timed out", id)
+	case <-cancel:
+		return ErrDownloadCancelled
+	}
+	e.checkPieceLength(id, t.tt.Info())
+	if validate == nil {
+		return nil
+	}
+
+	var b bytes.Buffer
+	if err := t.tt.Metainfo().Write(&b); err != nil {
+		return fmt.Errorf("Write metainfo %s error: %v", id, err)
+	}
+	return validate(b.Bytes())
+}
+
+// checkPieceLength warns when the torrent of id, created by another node,
+// does not have the piece length this node would use: the nodes would
+// create different torrents, with different infohashes, for the same
+// data
+func (e *BtEngine) checkPieceLength(id string, info *metainfo.InfoEx) {
+	if info == nil {
+		return
+	}
+	if expected := e.pieceLength(info.TotalLength()); info.PieceLength != expected {
+		log.Warnf("Torrent of %s has piece length %d, this node uses %d: "+
+			"the piece length settings must be the same on all nodes or "+
+			"they end up in separate swarms", id, info.PieceLength, expected)
+	}
+}
+
+// Magnet returns the magnet URI of the torrent of id
+func (e *BtEngine) Magnet(id string) (string, error) {
+	if !e.started {
+		return "", ErrBtEngineNotStart
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	info, ok := e.idInfos[id]
+	if !ok {
+		return "", ErrIdNotExist
+	}
+
+	t, err := e.getTorrent(info.InfoHash)
+	if err != nil {
+		return "", fmt.Errorf("Get magnet for %s failed: %v", id, err)
+	}
+	mi := t.tt.Metainfo()
+	m := mi.Magnet()
+	// As in GetTorrent, the web seeds only serve the files of layer
+	// torrents
+	if len(e.config.WebSeeds) > 0 && len(mi.Info.Files) == 0 {
+		m.WebSeeds = e.config.WebSeeds
+	}
+	return m.String(), nil
+}
+
 func (e *BtEngine) DeleteTorrent(id string) error {
 	if !e.started {
 		return ErrBtEngineNotStart
@@ -423,15 +1255,22 @@ func (e *BtEngine) DeleteTorrent(id string) error {
 
 	e.mut.Lock()
 	defer e.mut.Unlock()
+	return e.removeTorrent(id)
+}
 
+// removeTorrent deletes the stopped torrent of id with its files, e.mut
+// must be held
+func (e *BtEngine) removeTorrent(id string) error {
 	info, ok := e.idInfos[id]
 	if !ok {
+		e.removeGroupTorrent(id)
 		return nil
 	}
 
 	if info.Started {
 		return fmt.Errorf("Id %s torrent is still started, stop it first", id)
 	}
+	e.removeGroupTorrent(id)
 
 	infoHash := info.InfoHash
 	if err := e.deleteTorrent(infoHash); err != nil {
@@ -439,31 +1278,68 @@ func (e *BtEngine) DeleteTorrent(id string) error {
 	}
 	delete(e.idInfos, id)
 
-	// Remove data file and torrent file
-	dfn := e.GetFilePath(id)
+	// Remove data file and torrent file, the blobs of OCI directories are
+	// kept
+	dfn := path.Join(e.dataDir, layerFileName(id))
 	if err := os.Remove(dfn); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("Remove data file %s failed: %v", dfn, err)
 	}
+	if b := e.removeBlob(id); b != nil && b.temp != "" {
+		if err := os.Remove(b.temp); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Remove data file %s failed: %v", b.temp, err)
+		}
+	}
+	for _, b := range e.removeImageBlobs(id) {
+		if b.temp == "" || b.committed() {
+			continue
+		}
+		if err := os.Remove(b.temp); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Remove data file %s failed: %v", b.temp, err)
+		}
+	}
+	idn := e.GetImageDir(id)
+	if err := os.RemoveAll(idn); err != nil {
+		return fmt.Errorf("Remove image directory %s failed: %v", idn, err)
+	}
 
 	tfn := e.GetTorrentFilePath(id)
 	if err := os.Remove(tfn); err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("Remove torrent file %s failed: %v", tfn, err)
 	}
+	cfn := e.completionPath(infoHash)
+	if err := os.Remove(cfn); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Remove completion %s failed: %v", cfn, err)
+	}
 	return nil
 }
 
+// createTorrent writes the torrent file of layer id. Only the layer and
+// the configuration shared by the cluster are used, so that every node
+// holding the layer creates the same torrent.
 func (e *BtEngine) createTorrent(id string) error {
-	mi := metainfo.MetaInfo{
-		Announce: e.trackers[0],
-	}
-	mi.SetDefaults()
-	mi.Info.PieceLength = 1024 * 1024 //1MB
-
 	f := e.GetFilePath(id)
-	err := mi.Info.BuildFromFilePath(f)
+	fi, err := os.Stat(f)
+	if err != nil {
+		return fmt.Errorf("Create torrent file for %s failed: %v", f, err)
+	}
+	info, err := buildLayerInfo(id, f, e.pieceLength(fi.Size()), e.config.Private)
 	if err != nil {
 		return fmt.Errorf("Create torrent file for %s failed: %v", f, err)
 	}
+	return e.writeTorrent(id, info, true)
+}
+
+// writeTorrent writes the torrent file of id with info, the trackers and
+// the web seeds if webSeeds is set
+func (e *BtEngine) writeTorrent(id string, info *metainfo.InfoEx, webSeeds bool) error {
+	// Without tracker, torrents are only found through the DHT
+	mi := metainfo.MetaInfo{Info: *info}
+	if len(e.trackers) > 0 {
+		mi.Announce = e.trackers[0]
+	}
+	if webSeeds && len(e.config.WebSeeds) > 0 {
+		mi.URLList = e.config.WebSeeds
+	}
 
 	tfn := e.GetTorrentFilePath(id)
 	tFile, err := os.Create(tfn)
@@ -480,20 +1356,24 @@ func (e *BtEngine) createTorrent(id string) error {
 	return nil
 }
 
-func (e *BtEngine) addMagnet(magnetURI string) (*Torrent, error) {
-	//adds the torrent but does not start it
-	tt, err := e.client.AddMagnet(magnetURI)
-	if err != nil {
-		return nil, err
+// pieceLength returns the piece length of the torrent of a layer of size
+// bytes, PieceLength if set or else derived from the size only
+func (e *BtEngine) pieceLength(size int64) int64 {
+	c := e.config
+	if c.PieceLength > 0 {
+		return c.PieceLength
 	}
-	t := e.addTorrent(tt)
-
-	go func() {
-		<-t.tt.GotInfo()
-		e.startTorrent(t.InfoHash)
-	}()
-
-	return t, nil
+	min, max, target := c.MinPieceLength, c.MaxPieceLength, c.TargetPieces
+	if min <= 0 {
+		min = DefaultMinPieceLength
+	}
+	if max <= 0 {
+		max = DefaultMaxPieceLength
+	}
+	if target <= 0 {
+		target = DefaultTargetPieces
+	}
+	return layerPieceLength(size, min, max, target)
 }
 
 //GetTorrents moves torrents out of the anacrolix/torrent
@@ -550,7 +1430,7 @@ func (e *BtEngine) startTorrent(infohash string) error {
 	}
 	t.State = Started
 	if t.tt.Info() != nil {
-		t.tt.DownloadAll()
+		t.download()
 	}
 	return nil
 }
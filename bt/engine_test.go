@@ -0,0 +1,41 @@
+package bt
+
+import (
+	"testing"
+)
+
+func TestRateGroups(t *testing.T) {
+	e := NewBtEngine("/nonexistent", nil, nil)
+	limit := RateLimit{Upload: 1024, Download: 2048}
+
+	e.AddGroupTorrent("a", "layer1")
+	e.AddGroupTorrent("b", "layer1")
+	e.AddGroupTorrent("b", "layer2")
+	if err := e.SetGroupRateLimit("a", limit); err != nil {
+		t.Fatal(err)
+	}
+	if l := e.GetGroupRateLimit("a"); l != limit {
+		t.Errorf("expected %+v, got %+v", limit, l)
+	}
+	e.mut.Lock()
+	if n := len(e.torrentGroups("layer1")); n != 2 {
+		t.Errorf("expected layer1 in 2 groups, got %d", n)
+	}
+	e.mut.Unlock()
+
+	// A group without cap is kept while it has torrents
+	if err := e.SetGroupRateLimit("a", RateLimit{}); err != nil {
+		t.Fatal(err)
+	}
+	e.mut.Lock()
+	e.removeGroupTorrent("layer1")
+	e.removeGroupTorrent("layer2")
+	if len(e.groups) != 0 {
+		t.Errorf("expected no group left, got %d", len(e.groups))
+	}
+	e.mut.Unlock()
+
+	if err := e.SetGroupRateLimit("a", RateLimit{Upload: -1}); err == nil {
+		t.Error("expected error for negative limit")
+	}
+}
@@ -0,0 +1,426 @@
+package bt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	"golang.org/x/net/context"
+)
+
+// imageInfoDict is the info dictionary of an image torrent, a multi-file
+// torrent holding the manifest, the config and the layers of an image. The
+// files are named after their digest and the torrent commits to the digest
+// of the manifest.
+type imageInfoDict struct {
+	PieceLength int64               `bencode:"piece length"`
+	Pieces      []byte              `bencode:"pieces"`
+	Name        string              `bencode:"name"`
+	Files       []metainfo.FileInfo `bencode:"files"`
+	Digest      string              `bencode:"oci digest"`
+	Private     bool                `bencode:"private,omitempty"`
+}
+
+// ImageBlob is a blob of an image torrent. The file of the torrent is the
+// blob stored at Path, or Data written in the image directory if Path is
+// empty. A blob missing on this node is downloaded to Temp, on the file
+// system of Path, until CommitImageBlob. A Size below zero means unknown.
+type ImageBlob struct {
+	Digest string
+	Size   int64
+	Path   string
+	Temp   string
+	Data   []byte
+}
+
+// imageBlobsRecordName is the file of the image directory recording the
+// paths of the blobs of the image torrent
+const imageBlobsRecordName = "blobs.json"
+
+func imageDirName(id string) string {
+	return id + ".image"
+}
+
+// imageBlobPath returns the path of the blob with digest in an image
+// torrent, relative to the image directory
+func imageBlobPath(digest string) []string {
+	return strings.SplitN(digest, ":", 2)
+}
+
+// GetImageDir returns the directory of the blobs of the image torrent id
+func (e *BtEngine) GetImageDir(id string) string {
+	return path.Join(e.dataDir, imageDirName(id))
+}
+
+// GetImageBlobPath returns the path of the blob with digest in the image
+// torrent id
+func (e *BtEngine) GetImageBlobPath(id, digest string) string {
+	return path.Join(append([]string{e.GetImageDir(id)}, imageBlobPath(digest)...)...)
+}
+
+// imageFiles returns the blobs of an image torrent in order, without
+// duplicates
+func imageFiles(blobs []ImageBlob) []ImageBlob {
+	var files []ImageBlob
+	seen := map[string]bool{}
+	for _, b := range blobs {
+		if seen[b.Digest] {
+			continue
+		}
+		seen[b.Digest] = true
+		files = append(files, b)
+	}
+	return files
+}
+
+// placeData writes the blob b, known by its data, into the image directory
+// of the image torrent id
+func (e *BtEngine) placeData(id string, b ImageBlob) error {
+	dst := e.GetImageBlobPath(id, b.Digest)
+	if _, err := os.Lstat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, b.Data, 0600)
+}
+
+// setImageBlobs maps the files of the image torrent id onto blobs, those
+// with a path are read from it and the others from the image directory.
+// The paths are recorded to seed the image again after a restart.
+func (e *BtEngine) setImageBlobs(id string, blobs []ImageBlob) error {
+	paths := map[string]string{}
+	files := map[string]*layerBlob{}
+	for _, b := range imageFiles(blobs) {
+		if b.Path == "" {
+			if err := e.placeData(id, b); err != nil {
+				return fmt.Errorf("Write blob %s of image %s failed: %v", b.Digest, id, err)
+			}
+			continue
+		}
+		paths[b.Digest] = b.Path
+		files[b.Digest] = newLayerBlob(b.Path, b.Temp)
+	}
+
+	data, err := json.Marshal(paths)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(e.GetImageDir(id), 0700); err != nil {
+		return err
+	}
+	record := path.Join(e.GetImageDir(id), imageBlobsRecordName)
+	if err = ioutil.WriteFile(record, data, 0600); err != nil {
+		return fmt.Errorf("Write blob record %s failed: %v", record, err)
+	}
+
+	e.blobMut.Lock()
+	e.images[id] = files
+	e.blobMut.Unlock()
+	return nil
+}
+
+// loadImageBlobs maps the image torrent id onto the blobs recorded before
+// a restart
+func (e *BtEngine) loadImageBlobs(id string) error {
+	e.blobMut.Lock()
+	_, ok := e.images[id]
+	e.blobMut.Unlock()
+	if ok {
+		return nil
+	}
+	record := path.Join(e.GetImageDir(id), imageBlobsRecordName)
+	data, err := ioutil.ReadFile(record)
+	if os.IsNotExist(err) {
+		// Image torrents of older versions hold copies of their blobs
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	paths := map[string]string{}
+	if err = json.Unmarshal(data, &paths); err != nil {
+		return fmt.Errorf("Invalid blob record %s: %v", record, err)
+	}
+	files := map[string]*layerBlob{}
+	for digest, p := range paths {
+		files[digest] = newLayerBlob(p, "")
+	}
+
+	e.blobMut.Lock()
+	e.images[id] = files
+	e.blobMut.Unlock()
+	return nil
+}
+
+// removeImageBlobs forgets the blobs of the image torrent id, the blobs
+// themselves are kept
+func (e *BtEngine) removeImageBlobs(id string) map[string]*layerBlob {
+	e.blobMut.Lock()
+	defer e.blobMut.Unlock()
+	files := e.images[id]
+	delete(e.images, id)
+	return files
+}
+
+// imageBlob returns the blob holding the file with digest of the image
+// torrent id, nil if the file is in the image directory
+func (e *BtEngine) imageBlob(id, digest string) *layerBlob {
+	e.blobMut.Lock()
+	defer e.blobMut.Unlock()
+	return e.images[id][digest]
+}
+
+// openImageTorrent returns the storage of the image torrent id mapped onto
+// its blobs, or nil if it is not mapped
+func (e *BtEngine) openImageTorrent(id string, info *metainfo.InfoEx) *blobTorrent {
+	e.blobMut.Lock()
+	files, ok := e.images[id]
+	e.blobMut.Unlock()
+	if !ok {
+		return nil
+	}
+
+	var (
+		blobs   []*layerBlob
+		lengths []int64
+	)
+	for _, f := range info.UpvertedFiles() {
+		b := files[strings.Replace(strings.Join(f.Path, "/"), "/", ":", 1)]
+		if b == nil {
+			b = newLayerBlob(path.Join(append([]string{e.GetImageDir(id)}, f.Path...)...), "")
+		}
+		blobs = append(blobs, b)
+		lengths = append(lengths, f.Length)
+	}
+	return newBlobTorrent(blobs, lengths)
+}
+
+// CommitImageBlob verifies the blob with digest downloaded by the image
+// torrent id and renames it to its path, which is returned
+func (e *BtEngine) CommitImageBlob(id, digest string) (string, error) {
+	b := e.imageBlob(id, digest)
+	if b == nil {
+		return "", fmt.Errorf("Blob %s of image %s is not downloaded to a blob", digest, id)
+	}
+	if b.committed() {
+		return b.path, nil
+	}
+	if b.temp == "" {
+		return "", fmt.Errorf("Blob %s of image %s is missing", digest, id)
+	}
+	if err := b.commit(strings.TrimPrefix(digest, LayerDigestAlgorithm+":")); err != nil {
+		return "", err
+	}
+	return b.path, nil
+}
+
+// imageFilePath returns the file holding the blob with digest of the image
+// torrent id
+func (e *BtEngine) imageFilePath(id, digest string) string {
+	if b := e.imageBlob(id, digest); b != nil {
+		return b.path
+	}
+	return e.GetImageBlobPath(id, digest)
+}
+
+// buildImageInfo hashes the blobs of the image torrent id and returns its
+// info dictionary
+func (e *BtEngine) buildImageInfo(id, digest string, blobs []ImageBlob) (*metainfo.InfoEx, error) {
+	info := metainfo.Info{
+		Name: imageDirName(id),
+	}
+	var total int64
+	for _, b := range blobs {
+		fi, err := os.Stat(e.imageFilePath(id, b.Digest))
+		if err != nil {
+			return nil, err
+		}
+		info.Files = append(info.Files, metainfo.FileInfo{
+			Length: fi.Size(),
+			Path:   imageBlobPath(b.Digest),
+		})
+		total += fi.Size()
+	}
+	info.PieceLength = e.pieceLength(total)
+	err := info.GeneratePieces(func(fi metainfo.FileInfo) (io.ReadCloser, error) {
+		return os.Open(e.imageFilePath(id, strings.Replace(strings.Join(fi.Path, "/"), "/", ":", 1)))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error generating pieces: %v", err)
+	}
+
+	b, err := bencode.Marshal(&imageInfoDict{
+		PieceLength: info.PieceLength,
+		Pieces:      info.Pieces,
+		Name:        info.Name,
+		Files:       info.Files,
+		Digest:      digest,
+		Private:     e.config.Private,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ie := &metainfo.InfoEx{}
+	if err = ie.UnmarshalBencode(b); err != nil {
+		return nil, err
+	}
+	return ie, nil
+}
+
+// ValidateImageTorrent checks that torrentData describes exactly the blobs
+// of the image whose manifest has the given digest, in order
+func ValidateImageTorrent(torrentData []byte, digest string, blobs []ImageBlob) error {
+	mi, err := metainfo.Load(bytes.NewReader(torrentData))
+	if err != nil {
+		return fmt.Errorf("Load torrent data failed: %v", err)
+	}
+
+	d := &imageInfoDict{}
+	if err = bencode.Unmarshal(mi.Info.Bytes, d); err != nil {
+		return fmt.Errorf("Invalid info dictionary: %v", err)
+	}
+	if d.Digest != digest {
+		return fmt.Errorf("Torrent digest %q does not match manifest digest %s", d.Digest, digest)
+	}
+
+	id := strings.TrimPrefix(digest, LayerDigestAlgorithm+":")
+	if d.Name != imageDirName(id) {
+		return fmt.Errorf("Torrent name %q does not match image %s", d.Name, id)
+	}
+
+	files := imageFiles(blobs)
+	if len(d.Files) != len(files) {
+		return fmt.Errorf("Torrent has %d files, expect %d", len(d.Files), len(files))
+	}
+	var total int64
+	for i, f := range d.Files {
+		b := files[i]
+		if strings.Join(f.Path, "/") != strings.Join(imageBlobPath(b.Digest), "/") {
+			return fmt.Errorf("Torrent file %q does not match blob %s", strings.Join(f.Path, "/"), b.Digest)
+		}
+		if b.Size >= 0 && f.Length != b.Size {
+			return fmt.Errorf("Torrent length %d of %s does not match blob size %d", f.Length, b.Digest, b.Size)
+		}
+		total += f.Length
+	}
+
+	if d.PieceLength <= 0 {
+		return fmt.Errorf("Invalid torrent piece length %d", d.PieceLength)
+	}
+	numPieces := (total + d.PieceLength - 1) / d.PieceLength
+	if int64(len(d.Pieces)) != numPieces*20 {
+		return fmt.Errorf("Torrent has %d piece hashes, expect %d", len(d.Pieces)/20, numPieces)
+	}
+	return nil
+}
+
+// StartImageSeed seeds the image torrent id of the image whose manifest has
+// digest. blobs are the manifest and the config, written into the image
+// directory, and the layers, read from their blobs.
+func (e *BtEngine) StartImageSeed(id, digest string, blobs []ImageBlob) error {
+	if !e.started {
+		return ErrBtEngineNotStart
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	info, ok := e.idInfos[id]
+	if ok && info.Started {
+		info.Count++
+		return nil
+	}
+
+	if err := e.setImageBlobs(id, blobs); err != nil {
+		return err
+	}
+	tf := e.GetTorrentFilePath(id)
+	if _, err := os.Lstat(tf); err != nil {
+		log.Debugf("Create torrent file for image %s", id)
+		ie, err := e.buildImageInfo(id, digest, imageFiles(blobs))
+		if err != nil {
+			return fmt.Errorf("Create torrent file for image %s failed: %v", id, err)
+		}
+		if err = e.writeTorrent(id, ie, false); err != nil {
+			return err
+		}
+	}
+
+	metaInfo, err := metainfo.LoadFromFile(tf)
+	if err != nil {
+		return fmt.Errorf("Load torrent file failed: %v", err)
+	}
+	return e.addSeed(id, metaInfo)
+}
+
+// startImage seeds the image torrent id found in the data directory at
+// startup, from its torrent file
+func (e *BtEngine) startImage(id string) error {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	if info, ok := e.idInfos[id]; ok && info.Started {
+		return nil
+	}
+	if err := e.loadImageBlobs(id); err != nil {
+		return err
+	}
+	metaInfo, err := metainfo.LoadFromFile(e.GetTorrentFilePath(id))
+	if err != nil {
+		return fmt.Errorf("Load torrent file failed: %v", err)
+	}
+	return e.addSeed(id, metaInfo)
+}
+
+// StartImageLeecher downloads the image torrent id. blobs are the blobs of
+// the image: the manifest and the config with their data, the layers
+// stored on this node at their path, and the missing layers with the path
+// and temporary path to download them to. Only the missing layers are
+// downloaded.
+func (e *BtEngine) StartImageLeecher(ctx context.Context, id string, torrentData []byte, peers []string, blobs []ImageBlob, p *ProgressDownload) error {
+	if !e.started {
+		return ErrBtEngineNotStart
+	}
+
+	metaInfo, err := metainfo.Load(bytes.NewReader(torrentData))
+	if err != nil {
+		return fmt.Errorf("Load torrent file failed: %v", err)
+	}
+
+	wanted := map[string]bool{}
+	for _, b := range imageFiles(blobs) {
+		if b.Temp != "" {
+			wanted[strings.Join(imageBlobPath(b.Digest), "/")] = true
+		}
+	}
+	log.Debugf("Image %s wants %d of %d blobs", id, len(wanted), len(metaInfo.Info.UpvertedFiles()))
+
+	e.mut.Lock()
+	info, ok := e.idInfos[id]
+	started := ok && info.Started
+	e.mut.Unlock()
+	// A leech in progress is joined with its blobs
+	if !started {
+		if err = e.setImageBlobs(id, blobs); err != nil {
+			return err
+		}
+	}
+
+	add := func() (*torrent.Torrent, error) {
+		return e.client.AddTorrent(metaInfo)
+	}
+	return e.leech(ctx, id, add, peers, nil, nil, wanted, p)
+}
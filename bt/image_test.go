@@ -0,0 +1,120 @@
+package bt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func testBlob(content []byte) ImageBlob {
+	return ImageBlob{
+		Digest: layerDigest(fmt.Sprintf("%x", sha256.Sum256(content))),
+		Size:   int64(len(content)),
+		Data:   content,
+	}
+}
+
+func TestValidateImageTorrent(t *testing.T) {
+	root, err := ioutil.TempDir("", "bt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	e := NewBtEngine(root, nil, &Config{PieceLength: 1024})
+	if err := os.MkdirAll(e.dataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := testBlob([]byte(`{"schemaVersion": 2}`))
+	layer := testBlob(bytes.Repeat([]byte("layer"), 1000))
+	// Layers are read from their blob, not copied
+	layer.Path = path.Join(root, "blob")
+	if err := ioutil.WriteFile(layer.Path, layer.Data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	layer.Data = nil
+	blobs := []ImageBlob{manifest, testBlob([]byte("{}")), layer, layer}
+	id := manifest.Digest[len(LayerDigestAlgorithm)+1:]
+
+	files := imageFiles(blobs)
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files without the duplicate layer, got %d", len(files))
+	}
+	if err := e.setImageBlobs(id, blobs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(e.GetImageBlobPath(id, layer.Digest)); !os.IsNotExist(err) {
+		t.Errorf("expected no copy of the layer in the image directory, got %v", err)
+	}
+	info, err := e.buildImageInfo(id, manifest.Digest, files)
+	if err != nil {
+		t.Fatalf("buildImageInfo: unexpected error: %s", err)
+	}
+	// The storage of the image torrent reads the layer from its blob
+	it := e.openImageTorrent(id, info)
+	if it == nil {
+		t.Fatal("expected the image torrent mapped onto its blobs")
+	}
+	content := append(append([]byte{}, manifest.Data...), blobs[1].Data...)
+	content = append(content, bytes.Repeat([]byte("layer"), 1000)...)
+	buf := make([]byte, info.PieceLength)
+	if _, err := it.Piece(info.Piece(0)).ReadAt(buf, 0); err != nil {
+		t.Fatalf("ReadAt: unexpected error: %s", err)
+	}
+	if !bytes.Equal(buf, content[:len(buf)]) {
+		t.Errorf("expected the first piece read from the manifest, config and layer blob")
+	}
+	it.Close()
+	mi := metainfo.MetaInfo{Info: *info}
+	var b bytes.Buffer
+	if err := mi.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	unknown := layer
+	unknown.Size = -1
+	larger := layer
+	larger.Size++
+	for i, test := range []struct {
+		digest string
+		blobs  []ImageBlob
+		valid  bool
+	}{
+		{manifest.Digest, blobs, true},
+		{manifest.Digest, files, true},
+		{manifest.Digest, []ImageBlob{manifest, blobs[1], unknown}, true},
+		{manifest.Digest, []ImageBlob{manifest, blobs[1], larger}, false},
+		{manifest.Digest, []ImageBlob{manifest, layer, blobs[1]}, false},
+		{manifest.Digest, blobs[:2], false},
+		{layer.Digest, blobs, false},
+	} {
+		err := ValidateImageTorrent(b.Bytes(), test.digest, test.blobs)
+		if test.valid && err != nil {
+			t.Errorf("%d: unexpected error: %s", i, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+
+	// A layer torrent is not an image torrent
+	layerId := layer.Digest[len(LayerDigestAlgorithm)+1:]
+	info, err = buildLayerInfo(layerId, layer.Path, 1024, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi = metainfo.MetaInfo{Info: *info}
+	b.Reset()
+	if err := mi.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateImageTorrent(b.Bytes(), layer.Digest, []ImageBlob{layer}); err == nil {
+		t.Errorf("expected error for layer torrent")
+	}
+}
@@ -0,0 +1,175 @@
+package bt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/anacrolix/torrent/bencode"
+	"github.com/anacrolix/torrent/metainfo"
+	distdigests "github.com/docker/distribution/digest"
+)
+
+// LayerDigestAlgorithm is the digest algorithm of the layer IDs
+const LayerDigestAlgorithm = "sha256"
+
+// layerInfoDict is the info dictionary of a layer torrent. Besides the
+// standard single-file fields it carries the OCI digest of the layer, so the
+// infohash commits to the layer content the torrent claims to deliver.
+//
+// The dictionary is not signed by the seeder: leechers check it against the
+// digest and size of the manifest, itself checked by the signature policy,
+// and the completed layer against the digest. A signature would tie the
+// infohash to one seeder, while all holders of a layer must create the same
+// torrent, and could not be checked in magnet mode where the dictionary
+// comes from any peer.
+type layerInfoDict struct {
+	PieceLength int64  `bencode:"piece length"`
+	Pieces      []byte `bencode:"pieces"`
+	Name        string `bencode:"name"`
+	Length      int64  `bencode:"length"`
+	Digest      string `bencode:"oci digest"`
+	// Private torrents (BEP 27) are not shared on the DHT or by PEX
+	Private bool `bencode:"private,omitempty"`
+}
+
+// Default bounds of the piece length of layer torrents and number of
+// pieces aimed at. Every node must use the same values to create the same
+// torrent, and join the same swarm, for a layer.
+const (
+	DefaultMinPieceLength = 256 * 1024
+	DefaultMaxPieceLength = 16 * 1024 * 1024
+	DefaultTargetPieces   = 1024
+)
+
+// layerPieceLength returns the smallest power of two piece length between
+// min and max that splits a layer of size bytes in at most target pieces
+func layerPieceLength(size, min, max, target int64) int64 {
+	l := min
+	for l < max && (size+l-1)/l > target {
+		l *= 2
+	}
+	return l
+}
+
+func layerDigest(id string) string {
+	return LayerDigestAlgorithm + ":" + id
+}
+
+func layerFileName(id string) string {
+	return id + ".layer"
+}
+
+// VerifyLayerFile checks that the content of fn matches the layer digest
+func VerifyLayerFile(fn, digest string) error {
+	expected, err := distdigests.ParseDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return fmt.Errorf("Open layer file %s failed: %v", fn, err)
+	}
+	defer f.Close()
+
+	d, err := expected.Algorithm().FromReader(f)
+	if err != nil {
+		return fmt.Errorf("Digest layer file %s failed: %v", fn, err)
+	}
+	if d != expected {
+		return fmt.Errorf("Digest not match, expect: %s, actual: %s", expected, d)
+	}
+	return nil
+}
+
+// buildLayerInfo hashes the layer file fn and returns the info dictionary
+// of the torrent for layer id.
+func buildLayerInfo(id, fn string, pieceLength int64, private bool) (*metainfo.InfoEx, error) {
+	fi, err := os.Stat(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	info := metainfo.Info{
+		PieceLength: pieceLength,
+		Name:        layerFileName(id),
+		Length:      fi.Size(),
+	}
+	err = info.GeneratePieces(func(metainfo.FileInfo) (io.ReadCloser, error) {
+		return os.Open(fn)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error generating pieces: %v", err)
+	}
+
+	b, err := bencode.Marshal(&layerInfoDict{
+		PieceLength: info.PieceLength,
+		Pieces:      info.Pieces,
+		Name:        info.Name,
+		Length:      info.Length,
+		Digest:      layerDigest(id),
+		Private:     private,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the bytes again so that InfoEx keeps them and hashes them
+	ie := &metainfo.InfoEx{}
+	if err = ie.UnmarshalBencode(b); err != nil {
+		return nil, err
+	}
+	return ie, nil
+}
+
+// parseLayerInfo returns the layer info dictionary of a torrent
+func parseLayerInfo(mi *metainfo.MetaInfo) (*layerInfoDict, error) {
+	d := &layerInfoDict{}
+	if err := bencode.Unmarshal(mi.Info.Bytes, d); err != nil {
+		return nil, fmt.Errorf("Invalid info dictionary: %v", err)
+	}
+	return d, nil
+}
+
+// ValidateLayerTorrent checks that torrentData describes exactly the layer
+// with the given digest and size. A size below zero means unknown.
+func ValidateLayerTorrent(torrentData []byte, digest string, size int64) error {
+	mi, err := metainfo.Load(bytes.NewReader(torrentData))
+	if err != nil {
+		return fmt.Errorf("Load torrent data failed: %v", err)
+	}
+
+	d, err := parseLayerInfo(mi)
+	if err != nil {
+		return err
+	}
+
+	if d.Digest != digest {
+		return fmt.Errorf("Torrent digest %q does not match layer digest %s", d.Digest, digest)
+	}
+
+	id := strings.TrimPrefix(digest, LayerDigestAlgorithm+":")
+	if d.Name != layerFileName(id) {
+		return fmt.Errorf("Torrent name %q does not match layer %s", d.Name, id)
+	}
+
+	if len(mi.Info.Files) != 0 {
+		return fmt.Errorf("Torrent of layer %s must contain a single file", id)
+	}
+
+	if size >= 0 && d.Length != size {
+		return fmt.Errorf("Torrent length %d does not match layer size %d", d.Length, size)
+	}
+
+	if d.PieceLength <= 0 {
+		return fmt.Errorf("Invalid torrent piece length %d", d.PieceLength)
+	}
+	numPieces := (d.Length + d.PieceLength - 1) / d.PieceLength
+	if int64(len(d.Pieces)) != numPieces*20 {
+		return fmt.Errorf("Torrent has %d piece hashes, expect %d", len(d.Pieces)/20, numPieces)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package bt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestValidateLayerTorrent(t *testing.T) {
+	root, err := ioutil.TempDir("", "bt-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	content := bytes.Repeat([]byte("layer"), 1000)
+	id := fmt.Sprintf("%x", sha256.Sum256(content))
+	digest := layerDigest(id)
+	fn := filepath.Join(root, layerFileName(id))
+	if err := ioutil.WriteFile(fn, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := buildLayerInfo(id, fn, 1024, false)
+	if err != nil {
+		t.Fatalf("buildLayerInfo: unexpected error: %s", err)
+	}
+	mi := metainfo.MetaInfo{Info: *info}
+	var b bytes.Buffer
+	if err := mi.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		digest string
+		size   int64
+		valid  bool
+	}{
+		{digest, int64(len(content)), true},
+		{digest, -1, true},
+		{digest, int64(len(content)) + 1, false},
+		{layerDigest(fmt.Sprintf("%x", sha256.Sum256(nil))), int64(len(content)), false},
+	} {
+		err := ValidateLayerTorrent(b.Bytes(), test.digest, test.size)
+		if test.valid && err != nil {
+			t.Errorf("ValidateLayerTorrent(%s, %d): unexpected error: %s", test.digest, test.size, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("ValidateLayerTorrent(%s, %d): expected error", test.digest, test.size)
+		}
+	}
+
+	// A plain torrent of the same file is not bound to the digest
+	plain := metainfo.MetaInfo{}
+	plain.Info.PieceLength = 1024
+	if err := plain.Info.BuildFromFilePath(fn); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := plain.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateLayerTorrent(b.Bytes(), digest, int64(len(content))); err == nil {
+		t.Errorf("ValidateLayerTorrent: expected error for torrent without digest")
+	}
+}
+
+func TestLayerPieceLength(t *testing.T) {
+	for size, expected := range map[int64]int64{
+		0:                  256 << 10,
+		1000:               256 << 10,
+		256 << 20:          256 << 10,
+		256<<20 + 1:        512 << 10,
+		1 << 30:            1 << 20,
+		16 << 30:           16 << 20,
+		100 << 30:          16 << 20,
+		(1<<30)*3/2 + 1234: 2 << 20,
+	} {
+		if l := layerPieceLength(size, DefaultMinPieceLength, DefaultMaxPieceLength, DefaultTargetPieces); l != expected {
+			t.Errorf("layerPieceLength(%d): expected %d, got %d", size, expected, l)
+		}
+	}
+
+	// Bounds win over the number of pieces
+	if l := layerPieceLength(1000, 16<<10, 64<<10, 8); l != 16<<10 {
+		t.Errorf("expected the minimum piece length, got %d", l)
+	}
+	if l := layerPieceLength(1<<30, 16<<10, 64<<10, 8); l != 64<<10 {
+		t.Errorf("expected the maximum piece length, got %d", l)
+	}
+}
+
+func TestCreateTorrentDeterministic(t *testing.T) {
+	content := bytes.Repeat([]byte("layer"), 100000)
+	id := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	var infoHashes []metainfo.Hash
+	for _, trackers := range [][]string{nil, {"http://tracker:6882/announce"}} {
+		root, err := ioutil.TempDir("", "bt-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		e := NewBtEngine(root, trackers, &Config{})
+		for _, dir := range []string{e.dataDir, e.torrentDir} {
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(e.GetFilePath(id), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyLayerFile(e.GetFilePath(id), layerDigest(id)); err != nil {
+			t.Fatalf("VerifyLayerFile: unexpected error: %s", err)
+		}
+
+		if err := e.createTorrent(id); err != nil {
+			t.Fatal(err)
+		}
+		mi, err := metainfo.LoadFromFile(e.GetTorrentFilePath(id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mi.CreationDate != 0 || mi.Comment != "" || mi.CreatedBy != "" {
+			t.Errorf("unexpected node specific fields in %+v", mi)
+		}
+		infoHashes = append(infoHashes, mi.Info.Hash())
+	}
+	if infoHashes[0] != infoHashes[1] {
+		t.Errorf("expected the same infohash, got %s and %s", infoHashes[0].HexString(), infoHashes[1].HexString())
+	}
+
+	if err := VerifyLayerFile(os.DevNull, layerDigest(id)); err == nil {
+		t.Errorf("VerifyLayerFile: expected error for other content")
+	}
+}
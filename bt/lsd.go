@@ -0,0 +1,212 @@
+package bt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Local Service Discovery, BEP 14
+const (
+	lsdAddress = "239.192.152.143:6771"
+
+	lsdAnnounceInterval = 5 * time.Minute
+
+	// Keep announcements in a single unfragmented datagram
+	lsdMaxInfoHashes = 20
+)
+
+type lsdAnnounce struct {
+	Port       int
+	InfoHashes []string
+	Cookie     string
+}
+
+func formatLSDAnnounce(a *lsdAnnounce) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "BT-SEARCH * HTTP/1.1\r\n")
+	fmt.Fprintf(&b, "Host: %s\r\n", lsdAddress)
+	fmt.Fprintf(&b, "Port: %d\r\n", a.Port)
+	for _, ih := range a.InfoHashes {
+		fmt.Fprintf(&b, "Infohash: %s\r\n", ih)
+	}
+	if a.Cookie != "" {
+		fmt.Fprintf(&b, "cookie: %s\r\n", a.Cookie)
+	}
+	fmt.Fprintf(&b, "\r\n\r\n")
+	return b.Bytes()
+}
+
+func parseLSDAnnounce(data []byte) (*lsdAnnounce, error) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, err
+	}
+	if req.Method != "BT-SEARCH" {
+		return nil, fmt.Errorf("unexpected method %s", req.Method)
+	}
+
+	port, err := strconv.Atoi(req.Header.Get("Port"))
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid port %q", req.Header.Get("Port"))
+	}
+	a := &lsdAnnounce{
+		Port:   port,
+		Cookie: req.Header.Get("Cookie"),
+	}
+	for _, ih := range req.Header["Infohash"] {
+		ih = strings.ToLower(strings.TrimSpace(ih))
+		if b, err := hex.DecodeString(ih); err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("invalid infohash %q", ih)
+		}
+		a.InfoHashes = append(a.InfoHashes, ih)
+	}
+	if len(a.InfoHashes) == 0 {
+		return nil, fmt.Errorf("no infohash")
+	}
+	return a, nil
+}
+
+// lsd announces infohashes on the local network and reports the peers
+// announcing the same infohashes
+type lsd struct {
+	port   int
+	cookie string
+	group  *net.UDPAddr
+
+	mu      sync.Mutex
+	closed  bool
+	readers []*net.UDPConn
+	writers []*net.UDPConn
+}
+
+// newLSD joins the LSD multicast group on the interfaces named ifaces, or
+// on the default multicast interface if ifaces is empty
+func newLSD(port int, ifaces []string) (*lsd, error) {
+	group, err := net.ResolveUDPAddr("udp4", lsdAddress)
+	if err != nil {
+		return nil, err
+	}
+	cookie := make([]byte, 8)
+	if _, err = rand.Read(cookie); err != nil {
+		return nil, err
+	}
+	l := &lsd{
+		port:   port,
+		cookie: hex.EncodeToString(cookie),
+		group:  group,
+	}
+
+	var ifis []*net.Interface
+	for _, name := range ifaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("LSD interface %s: %v", name, err)
+		}
+		ifis = append(ifis, ifi)
+	}
+	if len(ifis) == 0 {
+		ifis = append(ifis, nil)
+	}
+
+	for _, ifi := range ifis {
+		r, err := net.ListenMulticastUDP("udp4", ifi, group)
+		if err != nil {
+			l.close()
+			return nil, fmt.Errorf("Join LSD group failed: %v", err)
+		}
+		l.readers = append(l.readers, r)
+
+		w, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			l.close()
+			return nil, err
+		}
+		if ifi != nil {
+			if err = setMulticastInterface(w, ifi); err != nil {
+				w.Close()
+				l.close()
+				return nil, fmt.Errorf("Set LSD interface %s failed: %v", ifi.Name, err)
+			}
+		}
+		l.writers = append(l.writers, w)
+	}
+	return l, nil
+}
+
+// serve calls found for every infohash announced by another peer, until
+// the lsd is closed
+func (l *lsd) serve(found func(infoHash string, peer *net.TCPAddr)) {
+	for _, r := range l.readers {
+		go func(r *net.UDPConn) {
+			buf := make([]byte, 2048)
+			for {
+				n, from, err := r.ReadFromUDP(buf)
+				if err != nil {
+					if !l.isClosed() {
+						log.Errorf("Read LSD announce failed: %v", err)
+					}
+					return
+				}
+				a, err := parseLSDAnnounce(buf[:n])
+				if err != nil {
+					log.Debugf("Ignore LSD announce from %s: %v", from, err)
+					continue
+				}
+				if a.Cookie == l.cookie {
+					continue
+				}
+				for _, ih := range a.InfoHashes {
+					found(ih, &net.TCPAddr{IP: from.IP, Port: a.Port})
+				}
+			}
+		}(r)
+	}
+}
+
+// announce sends infoHashes on every interface
+func (l *lsd) announce(infoHashes []string) {
+	for len(infoHashes) > 0 {
+		n := len(infoHashes)
+		if n > lsdMaxInfoHashes {
+			n = lsdMaxInfoHashes
+		}
+		msg := formatLSDAnnounce(&lsdAnnounce{
+			Port:       l.port,
+			InfoHashes: infoHashes[:n],
+			Cookie:     l.cookie,
+		})
+		infoHashes = infoHashes[n:]
+
+		for _, w := range l.writers {
+			if _, err := w.WriteToUDP(msg, l.group); err != nil && !l.isClosed() {
+				log.Warnf("Send LSD announce failed: %v", err)
+			}
+		}
+	}
+}
+
+func (l *lsd) isClosed() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closed
+}
+
+func (l *lsd) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for _, c := range append(l.readers, l.writers...) {
+		c.Close()
+	}
+}
@@ -0,0 +1,42 @@
+package bt
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setMulticastInterface makes conn send multicast datagrams on ifi
+func setMulticastInterface(conn *net.UDPConn, ifi *net.Interface) error {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return err
+	}
+	var ip4 net.IP
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			ip4 = ipnet.IP.To4()
+			break
+		}
+	}
+	if ip4 == nil {
+		return fmt.Errorf("no IPv4 address")
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var (
+		value  [4]byte
+		optErr error
+	)
+	copy(value[:], ip4)
+	err = raw.Control(func(fd uintptr) {
+		optErr = syscall.SetsockoptInet4Addr(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, value)
+	})
+	if err != nil {
+		return err
+	}
+	return optErr
+}
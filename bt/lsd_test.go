@@ -0,0 +1,45 @@
+package bt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLSDAnnounce(t *testing.T) {
+	a := &lsdAnnounce{
+		Port: 50007,
+		InfoHashes: []string{
+			"da39a3ee5e6b4b0d3255bfef95601890afd80709",
+			"0123456789abcdef0123456789abcdef01234567",
+		},
+		Cookie: "c00k1e",
+	}
+	parsed, err := parseLSDAnnounce(formatLSDAnnounce(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(a, parsed) {
+		t.Fatalf("expected %+v, got %+v", a, parsed)
+	}
+
+	// Infohashes are case insensitive, the cookie is optional
+	parsed, err = parseLSDAnnounce([]byte("BT-SEARCH * HTTP/1.1\r\nHost: 239.192.152.143:6771\r\nPort: 6881\r\nInfohash: DA39A3EE5E6B4B0D3255BFEF95601890AFD80709\r\n\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Port != 6881 || parsed.Cookie != "" || parsed.InfoHashes[0] != "da39a3ee5e6b4b0d3255bfef95601890afd80709" {
+		t.Fatalf("unexpected announce %+v", parsed)
+	}
+
+	for _, msg := range []string{
+		"GET / HTTP/1.1\r\nHost: x\r\nPort: 6881\r\nInfohash: da39a3ee5e6b4b0d3255bfef95601890afd80709\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nHost: x\r\nPort: 0\r\nInfohash: da39a3ee5e6b4b0d3255bfef95601890afd80709\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nHost: x\r\nPort: 6881\r\nInfohash: da39\r\n\r\n",
+		"BT-SEARCH * HTTP/1.1\r\nHost: x\r\nPort: 6881\r\n\r\n",
+		"garbage",
+	} {
+		if _, err := parseLSDAnnounce([]byte(msg)); err == nil {
+			t.Errorf("expected error for %q", msg)
+		}
+	}
+}
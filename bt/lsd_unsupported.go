@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package bt
+
+import (
+	"fmt"
+	"net"
+)
+
+func setMulticastInterface(conn *net.UDPConn, ifi *net.Interface) error {
+	return fmt.Errorf("selecting the multicast interface is only supported on linux")
+}
@@ -1,6 +1,7 @@
 package bt
 
 import (
+	"expvar"
 	"time"
 
 	"github.com/anacrolix/torrent"
@@ -29,18 +30,25 @@ type Torrent struct {
 	InfoHash string
 	Name     string
 	tt       *torrent.Torrent
+	// Files to download by path in the torrent, all of them if nil
+	wanted map[string]bool
 
 	State        State
 	Loaded       bool
 	Seeding      bool
 	Size         int64
 	Downloaded   int64
+	Uploaded     int64
 	Percent      float32
 	DownloadRate float32
+	Peers        int
+	KnownPeers   int
 	updatedAt    time.Time
 }
 
 func (t *Torrent) Update() {
+	t.Uploaded = t.tt.Stats().DataBytesWritten
+	t.Peers, t.KnownPeers = t.tt.NumPeers()
 	t.Loaded = t.tt.Info() != nil
 	if t.tt.Info() != nil {
 		t.Size = t.tt.Length()
@@ -65,6 +73,125 @@ func (t *Torrent) Update() {
 	}
 }
 
+func (t *Torrent) status(id string) *Status {
+	return &Status{
+		Id:           id,
+		State:        t.State.String(),
+		Completed:    t.Downloaded,
+		TotalLen:     t.Size,
+		Seeding:      t.Seeding,
+		Uploaded:     t.Uploaded,
+		DownloadRate: t.DownloadRate,
+		Peers:        t.Peers,
+		KnownPeers:   t.KnownPeers,
+	}
+}
+
+// download requests the pieces of the wanted files
+func (t *Torrent) download() {
+	if t.wanted == nil {
+		t.tt.DownloadAll()
+		return
+	}
+	for _, f := range t.tt.Files() {
+		if t.wanted[f.DisplayPath()] {
+			f.Download()
+		}
+	}
+}
+
+// progress returns the bytes of the wanted files downloaded and their
+// total length
+func (t *Torrent) progress() (completed, total int64) {
+	if t.wanted == nil {
+		return t.tt.BytesCompleted(), t.tt.Info().TotalLength()
+	}
+	for _, f := range t.tt.Files() {
+		if !t.wanted[f.DisplayPath()] {
+			continue
+		}
+		for _, ps := range f.State() {
+			if ps.Complete {
+				completed += ps.Bytes
+			}
+			total += ps.Bytes
+		}
+	}
+	return completed, total
+}
+
+// waitComplete blocks until the wanted data of the torrent is downloaded,
+// or cancel is closed
+func (t *Torrent) waitComplete(cancel <-chan struct{}) error {
+	select {
+	case <-t.tt.GotInfo():
+	case <-cancel:
+		return ErrDownloadCancelled
+	}
+	for {
+		completed, total := t.progress()
+		if completed >= total {
+			return nil
+		}
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-cancel:
+			return ErrDownloadCancelled
+		}
+	}
+}
+
+// waitChecked blocks until no piece of the torrent is hashed or queued for
+// hash
+func (t *Torrent) waitChecked() {
+	<-t.tt.GotInfo()
+	for {
+		checking := false
+		for _, r := range t.tt.PieceStateRuns() {
+			if r.Checking {
+				checking = true
+				break
+			}
+		}
+		if !checking {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Counters is the sum of the piece verifications, tracker announces,
+// refused peers and web seed downloads of all torrents since the daemon
+// started
+type Counters struct {
+	PiecesVerified        int64
+	PiecesFailed          int64
+	TrackerAnnounceErrors int64
+	PeersUnauthenticated  int64
+	WebSeedBytes          int64
+	WebSeedPieces         int64
+	WebSeedErrors         int64
+}
+
+func GetCounters() Counters {
+	return Counters{
+		PiecesVerified:        expvarInt("pieceHashedCorrect"),
+		PiecesFailed:          expvarInt("pieceHashedNotCorrect"),
+		TrackerAnnounceErrors: expvarInt("trackerAnnounceErrors"),
+		PeersUnauthenticated:  expvarInt("peersUnauthenticated"),
+		WebSeedBytes:          webSeedBytes.Value(),
+		WebSeedPieces:         webSeedPieces.Value(),
+		WebSeedErrors:         webSeedErrors.Value(),
+	}
+}
+
+func expvarInt(name string) int64 {
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		return v.Value()
+	}
+	return 0
+}
+
 func percent(n, total int64) float32 {
 	if total == 0 {
 		return float32(0)
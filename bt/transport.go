@@ -0,0 +1,80 @@
+package bt
+
+import (
+	"fmt"
+	"net"
+)
+
+// Encryption modes of the peer wire
+const (
+	EncryptionDisabled  = "disable"
+	EncryptionPreferred = "prefer"
+	EncryptionRequired  = "require"
+)
+
+// TransportInfo is the effective configuration of the peer wire
+type TransportInfo struct {
+	ListenAddr string
+	TCP        bool
+	UTP        bool
+	Encryption string
+}
+
+// resolveListenHost returns the address to listen on for host, an IP
+// address or the name of an interface. Empty means all IPv4 addresses.
+func resolveListenHost(host string) (string, error) {
+	if host == "" {
+		return "0.0.0.0", nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return host, nil
+	}
+
+	ifi, err := net.InterfaceByName(host)
+	if err != nil {
+		return "", fmt.Errorf("Invalid listen host %s: %v", host, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return "", err
+	}
+	// Prefer IPv4, link-local IPv6 addresses would need a zone
+	var ip6 net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+		if ip6 == nil {
+			ip6 = ipnet.IP
+		}
+	}
+	if ip6 == nil {
+		return "", fmt.Errorf("Interface %s has no usable address", host)
+	}
+	return ip6.String(), nil
+}
+
+func encryptionMode(c *Config) string {
+	switch {
+	case c.DisableEncryption:
+		return EncryptionDisabled
+	case c.RequireEncryption:
+		return EncryptionRequired
+	default:
+		return EncryptionPreferred
+	}
+}
+
+// TransportInfo returns the effective configuration of the peer wire
+func (e *BtEngine) TransportInfo() TransportInfo {
+	return TransportInfo{
+		ListenAddr: e.ListenAddr(),
+		TCP:        !e.config.DisableTCP,
+		UTP:        !e.config.DisableUTP,
+		Encryption: encryptionMode(e.config),
+	}
+}
@@ -0,0 +1,154 @@
+package bt
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/anacrolix/torrent/metainfo"
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+// Pieces no peer has for webSeedInterval are fetched from the web seeds
+const webSeedInterval = 5 * time.Second
+const webSeedReadSize = 16 * 1024
+
+var (
+	webSeedBytes  = expvar.NewInt("webSeedBytes")
+	webSeedPieces = expvar.NewInt("webSeedPieces")
+	webSeedErrors = expvar.NewInt("webSeedErrors")
+)
+
+// WebSeedPath is the path under which seeders serve the layer files, web
+// seed URLs end with it
+const WebSeedPath = "/layers/"
+
+func newWebSeedLimiter(limit int) *rate.Limiter {
+	if limit <= 0 {
+		return rate.NewLimiter(rate.Inf, webSeedReadSize)
+	}
+	return rate.NewLimiter(rate.Limit(limit), webSeedReadSize)
+}
+
+// webSeedURLs returns the web seeds (BEP 19) of the torrent
+func webSeedURLs(mi *metainfo.MetaInfo) []string {
+	var urls []string
+	switch l := mi.URLList.(type) {
+	case string:
+		if l != "" {
+			urls = append(urls, l)
+		}
+	case []string:
+		urls = append(urls, l...)
+	case []interface{}:
+		for _, u := range l {
+			if s, ok := u.(string); ok && s != "" {
+				urls = append(urls, s)
+			}
+		}
+	}
+	return urls
+}
+
+// webSeedFileURL returns the URL of the file of a single file torrent, a
+// web seed ending with a slash is a directory holding the file
+func webSeedFileURL(seed, name string) string {
+	if strings.HasSuffix(seed, "/") {
+		return seed + name
+	}
+	return seed
+}
+
+// webSeed fetches the pieces of t that the swarm cannot supply from the
+// web seeds, until t is complete or dropped
+func (e *BtEngine) webSeed(t *Torrent, urls []string) {
+	<-t.tt.GotInfo()
+	info := t.tt.Info()
+
+	var unavailable map[int]bool
+	for {
+		time.Sleep(webSeedInterval)
+
+		e.mut.Lock()
+		active := e.ts[t.InfoHash] == t
+		e.mut.Unlock()
+		if !active || t.tt.BytesCompleted() >= info.TotalLength() {
+			return
+		}
+
+		pieces := t.tt.UnavailablePieces()
+		still := make(map[int]bool, len(pieces))
+		for _, i := range pieces {
+			still[i] = true
+			// Give the swarm one interval to supply the piece
+			if !unavailable[i] {
+				continue
+			}
+			if err := e.fetchWebSeedPiece(t, urls, info.Name, i); err != nil {
+				webSeedErrors.Add(1)
+				log.Warnf("Fetch piece %d of %s from web seeds failed: %v", i, t.InfoHash, err)
+			}
+		}
+		unavailable = still
+	}
+}
+
+// fetchWebSeedPiece downloads piece from the first web seed serving it
+func (e *BtEngine) fetchWebSeedPiece(t *Torrent, urls []string, name string, piece int) error {
+	off, length := t.tt.PieceBounds(piece)
+	var err error
+	for _, u := range urls {
+		var data []byte
+		data, err = e.fetchRange(webSeedFileURL(u, name), off, length)
+		if err != nil {
+			continue
+		}
+		if err = t.tt.WritePiece(piece, data); err != nil {
+			return err
+		}
+		webSeedPieces.Add(1)
+		log.Debugf("Fetched piece %d of %s from %s", piece, t.InfoHash, u)
+		return nil
+	}
+	return err
+}
+
+// fetchRange reads length bytes at off of the file at url with an HTTP
+// range request, under the web seed rate limit
+func (e *BtEngine) fetchRange(url string, off, length int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	resp, err := e.webSeedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("Get %s returned %s", url, resp.Status)
+	}
+
+	data := make([]byte, length)
+	for n := 0; n < len(data); {
+		m := len(data) - n
+		if m > webSeedReadSize {
+			m = webSeedReadSize
+		}
+		if err = e.webSeedLimiter.WaitN(context.Background(), m); err != nil {
+			return nil, err
+		}
+		if _, err = io.ReadFull(resp.Body, data[n:n+m]); err != nil {
+			return nil, fmt.Errorf("Read %s failed: %v", url, err)
+		}
+		webSeedBytes.Add(int64(m))
+		n += m
+	}
+	return data, nil
+}
@@ -0,0 +1,56 @@
+package bt
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+func TestWebSeedURLs(t *testing.T) {
+	seeds := []string{"http://seeder1/layers/", "http://seeder2/layers/"}
+	mi := metainfo.MetaInfo{URLList: seeds}
+	var b bytes.Buffer
+	if err := mi.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := metainfo.Load(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls := webSeedURLs(loaded); !reflect.DeepEqual(urls, seeds) {
+		t.Errorf("expected %v, got %v", seeds, urls)
+	}
+
+	for list, expected := range map[interface{}][]string{
+		"http://seeder/layers/": {"http://seeder/layers/"},
+		"":                      nil,
+	} {
+		if urls := webSeedURLs(&metainfo.MetaInfo{URLList: list}); !reflect.DeepEqual(urls, expected) {
+			t.Errorf("%q: expected %v, got %v", list, expected, urls)
+		}
+	}
+	if urls := webSeedURLs(&metainfo.MetaInfo{}); urls != nil {
+		t.Errorf("expected no web seed, got %v", urls)
+	}
+
+	if u := webSeedFileURL("http://seeder/layers/", "abc.layer"); u != "http://seeder/layers/abc.layer" {
+		t.Errorf("unexpected directory seed URL %s", u)
+	}
+	if u := webSeedFileURL("http://seeder/abc.layer", "abc.layer"); u != "http://seeder/abc.layer" {
+		t.Errorf("unexpected file seed URL %s", u)
+	}
+}
+
+func TestMagnetWebSeeds(t *testing.T) {
+	seeds := []string{"http://seeder1/layers/", "http://seeder2/layers/?a=b&c"}
+	m := metainfo.Magnet{DisplayName: "abc.layer", WebSeeds: seeds}
+	parsed, err := metainfo.ParseMagnetURI(m.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.WebSeeds, seeds) {
+		t.Errorf("expected %v, got %v", seeds, parsed.WebSeeds)
+	}
+}
@@ -6,16 +6,20 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"text/tabwriter"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
+	"github.com/docker/go-connections/tlsconfig"
 	netcontext "golang.org/x/net/context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/grpclog"
 
 	"github.com/hustcat/oci-torrent/api/grpc/types"
@@ -61,11 +65,26 @@ func main() {
 			Value: 1 * time.Second,
 			Usage: "GRPC connection timeout",
 		},
+		cli.StringFlag{
+			Name:  "tlscacert",
+			Usage: "trust certs signed only by this CA, required by tcp addresses",
+		},
+		cli.StringFlag{
+			Name:  "tlscert",
+			Usage: "path to TLS certificate file",
+		},
+		cli.StringFlag{
+			Name:  "tlskey",
+			Usage: "path to TLS key file",
+		},
 	}
 	app.Commands = []cli.Command{
 		startDownloadCommand,
 		stopDownloadCommand,
 		statusCommand,
+		rateLimitCommand,
+		torrentCommand,
+		infoCommand,
 		versionCommand,
 	}
 	app.Before = func(context *cli.Context) error {
@@ -92,6 +111,39 @@ var versionCommand = cli.Command{
 	},
 }
 
+var infoCommand = cli.Command{
+	Name:  "info",
+	Usage: "return the effective daemon settings",
+	Action: func(context *cli.Context) {
+		c := getClient(context)
+		resp, err := c.GetDaemonInfo(netcontext.Background(), &types.GetDaemonInfoRequest{})
+		if err != nil {
+			fatal(err.Error(), 1)
+		}
+
+		var transports []string
+		if resp.Tcp {
+			transports = append(transports, "tcp")
+		}
+		if resp.Utp {
+			transports = append(transports, "utp")
+		}
+		w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+		fmt.Fprintf(w, "Bittorrent:\t%v\n", resp.BtEnabled)
+		fmt.Fprintf(w, "Seeder:\t%v\n", resp.Seeder)
+		fmt.Fprintf(w, "Listen address:\t%s\n", resp.ListenAddr)
+		fmt.Fprintf(w, "Transports:\t%s\n", strings.Join(transports, ","))
+		fmt.Fprintf(w, "Encryption:\t%s\n", resp.Encryption)
+		fmt.Fprintf(w, "Trackers:\t%s\n", strings.Join(resp.Trackers, ","))
+		fmt.Fprintf(w, "DHT:\t%v\n", resp.Dht)
+		fmt.Fprintf(w, "LSD:\t%v\n", resp.Lsd)
+		fmt.Fprintf(w, "Private:\t%v\n", resp.Private)
+		fmt.Fprintf(w, "Zone:\t%s\n", resp.Zone)
+		fmt.Fprintf(w, "Rack:\t%s\n", resp.Rack)
+		w.Flush()
+	},
+}
+
 var startDownloadCommand = cli.Command{
 	Name:      "start",
 	Usage:     "start download",
@@ -111,6 +163,18 @@ var startDownloadCommand = cli.Command{
 			Value: "",
 			Usage: "use `PASSWORD` for accessing the registry",
 		},
+		cli.StringFlag{
+			Name:  "layer-order",
+			Usage: "download smaller layers first (size) or base layers first (base), the daemon default if empty",
+		},
+		cli.StringSliceFlag{
+			Name:  "priority-layer",
+			Usage: "`DIGEST` of a layer downloaded before the others, may be repeated",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "cancel the download if not done within `DURATION`, no limit if 0",
+		},
 	},
 	Action: func(context *cli.Context) {
 		var (
@@ -136,13 +200,29 @@ var startDownloadCommand = cli.Command{
 				fatal(err.Error(), 1)
 			}
 		}
+		// The daemon cancels the download once interrupted or timed out
+		ctx, cancel := netcontext.WithCancel(netcontext.Background())
+		if timeout := context.Duration("timeout"); timeout > 0 {
+			ctx, cancel = netcontext.WithTimeout(ctx, timeout)
+		}
+		defer cancel()
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigc
+			cancel()
+		}()
+
 		c := getClient(context)
-		_, err = c.StartDownload(netcontext.Background(), &types.StartDownloadRequest{
+		_, err = c.StartDownload(ctx, &types.StartDownloadRequest{
 			Source:   image,
 			Stdout:   s.stdout,
 			Stderr:   s.stderr,
 			Username: context.String("username"),
 			Password: context.String("password"),
+
+			LayerOrder:     context.String("layer-order"),
+			PriorityLayers: context.StringSlice("priority-layer"),
 		})
 		if err != nil {
 			fatal(err.Error(), 1)
@@ -220,9 +300,127 @@ var statusCommand = cli.Command{
 				s.Size, s.Seeding)
 		}
 		w.Flush()
+
+		window := resp.BandwidthWindow
+		if window == "" {
+			window = "none"
+		}
+		fmt.Printf("\nBandwidth window: %s, upload: %s, download: %s\n", window,
+			formatRate(resp.UploadRateLimit), formatRate(resp.DownloadRateLimit))
+	},
+}
+
+var torrentCommand = cli.Command{
+	Name:  "torrent",
+	Usage: "show the torrents of images",
+	Subcommands: []cli.Command{
+		magnetCommand,
 	},
 }
 
+var magnetCommand = cli.Command{
+	Name:      "magnet",
+	Usage:     "print the magnet links of the layers of an image",
+	ArgsUsage: "IMAGE",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "layer",
+			Usage: "print the magnet link of the layer of `ID` instead of an image",
+		},
+	},
+	Action: func(context *cli.Context) {
+		var (
+			image = context.Args().Get(0)
+			id    = context.String("layer")
+		)
+
+		if image == "" && id == "" {
+			fatal("image cannot be empty", ExitStatusMissingArg)
+		}
+
+		c := getClient(context)
+		resp, err := c.GetMagnets(netcontext.Background(), &types.GetMagnetsRequest{
+			Source: image,
+			Id:     id,
+		})
+		if err != nil {
+			fatal(err.Error(), 1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+		fmt.Fprintf(w, "ID\tMAGNET\n")
+		for _, m := range resp.Magnets {
+			fmt.Fprintf(w, "%s\t%s\n", TruncateID(m.Id), m.Magnet)
+		}
+		w.Flush()
+	},
+}
+
+var rateLimitCommand = cli.Command{
+	Name:      "ratelimit",
+	Usage:     "get or set rate limits, global or of an image",
+	ArgsUsage: "[IMAGE]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "layer",
+			Usage: "limit the layer of `ID` instead of an image",
+		},
+		cli.IntFlag{
+			Name:  "upload",
+			Usage: "upload rate limit in bytes per second, 0 for unlimited",
+		},
+		cli.IntFlag{
+			Name:  "download",
+			Usage: "download rate limit in bytes per second, 0 for unlimited",
+		},
+	},
+	Action: func(context *cli.Context) {
+		var (
+			image = context.Args().Get(0)
+			id    = context.String("layer")
+		)
+
+		c := getClient(context)
+		resp, err := c.GetRateLimit(netcontext.Background(), &types.GetRateLimitRequest{
+			Source: image,
+			Id:     id,
+		})
+		if err != nil {
+			fatal(err.Error(), 1)
+		}
+
+		if context.IsSet("upload") || context.IsSet("download") {
+			if context.IsSet("upload") {
+				resp.Upload = int64(context.Int("upload"))
+			}
+			if context.IsSet("download") {
+				resp.Download = int64(context.Int("download"))
+			}
+			_, err = c.SetRateLimit(netcontext.Background(), &types.SetRateLimitRequest{
+				Source:   image,
+				Id:       id,
+				Upload:   resp.Upload,
+				Download: resp.Download,
+			})
+			if err != nil {
+				fatal(err.Error(), 1)
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 20, 1, 3, ' ', 0)
+		fmt.Fprintf(w, "UPLOAD\tDOWNLOAD\n")
+		fmt.Fprintf(w, "%s\t%s\n", formatRate(resp.Upload), formatRate(resp.Download))
+		w.Flush()
+	},
+}
+
+func formatRate(rate int64) string {
+	if rate == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d", rate)
+}
+
 func fatal(err string, code int) {
 	fmt.Fprintf(os.Stderr, "[ctr] %s\n", err)
 	panic(exit{code})
@@ -238,7 +436,12 @@ func getClient(ctx *cli.Context) types.APIClient {
 
 	// reset the logger for grpc to log to dev/null so that it does not mess with our stdio
 	grpclog.SetLogger(log.New(ioutil.Discard, "", log.LstdFlags))
-	dialOpts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithTimeout(ctx.GlobalDuration("conn-timeout"))}
+	dialOpts := []grpc.DialOption{grpc.WithTimeout(ctx.GlobalDuration("conn-timeout"))}
+	if bindParts[0] == "tcp" {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(getClientCredentials(ctx, bindParts[1])))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
 	dialOpts = append(dialOpts,
 		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
 			return net.DialTimeout(bindParts[0], bindParts[1], timeout)
@@ -250,3 +453,26 @@ func getClient(ctx *cli.Context) types.APIClient {
 	}
 	return types.NewAPIClient(conn)
 }
+
+// getClientCredentials returns the mutual TLS credentials for the daemon at
+// the TCP address
+func getClientCredentials(ctx *cli.Context, address string) credentials.TransportCredentials {
+	opts := tlsconfig.Options{
+		CAFile:   ctx.GlobalString("tlscacert"),
+		CertFile: ctx.GlobalString("tlscert"),
+		KeyFile:  ctx.GlobalString("tlskey"),
+	}
+	if opts.CAFile == "" || opts.CertFile == "" || opts.KeyFile == "" {
+		fatal("tcp address requires --tlscacert, --tlscert and --tlskey", 1)
+	}
+	tlsConfig, err := tlsconfig.Client(opts)
+	if err != nil {
+		fatal(err.Error(), 1)
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		fatal(err.Error(), 1)
+	}
+	tlsConfig.ServerName = host
+	return credentials.NewTLS(tlsConfig)
+}
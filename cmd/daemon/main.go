@@ -1,7 +1,10 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
@@ -10,31 +13,42 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/cli"
 	"github.com/docker/docker/pkg/listeners"
+	"github.com/docker/go-connections/tlsconfig"
 
 	"github.com/hustcat/oci-torrent/api/grpc/server"
 	"github.com/hustcat/oci-torrent/api/grpc/types"
+	"github.com/hustcat/oci-torrent/bt"
 	"github.com/hustcat/oci-torrent/daemon"
+	"github.com/hustcat/oci-torrent/metrics"
 	"github.com/hustcat/oci-torrent/version"
 )
 
 const (
-	usage               = "OCI image torrent daemon"
-	defaultRootDir      = "/data/oci-torrentd"
-	defaultGRPCEndpoint = "unix:///run/oci-torrentd/oci-torrentd.sock"
+	usage = "OCI image torrent daemon"
 )
 
 var daemonFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "config",
+		Usage: "path to the daemon configuration file, reloaded on SIGHUP",
+	},
 	cli.BoolFlag{
 		Name:  "debug",
 		Usage: "enable debug output in the logs",
 	},
+	cli.StringFlag{
+		Name:  "log-level",
+		Value: daemon.DefaultLogLevel,
+		Usage: "log level: debug, info, warn, error, fatal or panic",
+	},
 	cli.StringFlag{
 		Name:  "root-dir",
-		Value: defaultRootDir,
+		Value: daemon.DefaultRoot,
 		Usage: "daemon root directory",
 	},
 	cli.BoolFlag{
@@ -53,6 +67,99 @@ var daemonFlags = []cli.Flag{
 		Name:  "seeder-addr",
 		Usage: "bittorrent seeder address, proto://address",
 	},
+	cli.IntFlag{
+		Name:  "bt-port",
+		Value: daemon.DefaultBtPort,
+		Usage: "bittorrent incoming port",
+	},
+	cli.IntFlag{
+		Name:  "bt-max-port",
+		Usage: "highest bittorrent incoming port tried when bt-port is taken",
+	},
+	cli.StringFlag{
+		Name:  "bt-listen-host",
+		Usage: "IP address or interface name bittorrent listens on, default to all IPv4 addresses",
+	},
+	cli.BoolTFlag{
+		Name:  "bt-disable-encryption",
+		Usage: "disable bittorrent protocol encryption",
+	},
+	cli.StringFlag{
+		Name:  "bt-encryption",
+		Usage: "bittorrent protocol encryption: disable, prefer or require, overrides bt-disable-encryption",
+	},
+	cli.BoolFlag{
+		Name:  "bt-disable-tcp",
+		Usage: "disable the TCP transport of bittorrent",
+	},
+	cli.BoolTFlag{
+		Name:  "bt-disable-utp",
+		Usage: "disable the uTP transport of bittorrent",
+	},
+	cli.IntFlag{
+		Name:  "bt-piece-length",
+		Usage: "piece length of the torrents created by the seeder, derived from the layer size if 0",
+	},
+	cli.IntFlag{
+		Name:  "bt-min-piece-length",
+		Value: bt.DefaultMinPieceLength,
+		Usage: "minimum piece length of the torrents whose piece length is derived from the layer size",
+	},
+	cli.IntFlag{
+		Name:  "bt-max-piece-length",
+		Value: bt.DefaultMaxPieceLength,
+		Usage: "maximum piece length of the torrents whose piece length is derived from the layer size",
+	},
+	cli.IntFlag{
+		Name:  "bt-target-pieces",
+		Value: bt.DefaultTargetPieces,
+		Usage: "number of pieces the piece length derived from the layer size aims at",
+	},
+	cli.BoolFlag{
+		Name:  "verify-on-start",
+		Usage: "hash the pieces of all torrents again at startup instead of trusting their recorded completion",
+	},
+	cli.IntFlag{
+		Name:  "bt-load-concurrency",
+		Value: bt.DefaultLoadConcurrency,
+		Usage: "number of torrents loaded, and hashed if needed, at once at startup",
+	},
+	cli.BoolFlag{
+		Name:  "bt-dht",
+		Usage: "find peers through a private DHT bootstrapped from the seeders",
+	},
+	cli.StringSliceFlag{
+		Name:  "bt-dht-node",
+		Usage: "private DHT bootstrap node host:port, default to the seeders on bt-port",
+	},
+	cli.BoolFlag{
+		Name:  "bt-lsd",
+		Usage: "find peers on the local network with local service discovery (BEP 14)",
+	},
+	cli.StringSliceFlag{
+		Name:  "bt-lsd-interface",
+		Usage: "network interface used by local service discovery, default to the system multicast interface",
+	},
+	cli.BoolFlag{
+		Name:  "bt-magnet",
+		Usage: "get only the magnet links of layers from the seeder and the torrent info from peers",
+	},
+	cli.BoolFlag{
+		Name:  "bt-image-torrent",
+		Usage: "also seed, or download, each image as one torrent holding all its blobs",
+	},
+	cli.BoolFlag{
+		Name:  "bt-private",
+		Usage: "create private torrents, not shared on the DHT, by PEX or LSD",
+	},
+	cli.StringFlag{
+		Name:  "bt-peer-secret-file",
+		Usage: "file of the secret shared by the cluster, peers that cannot prove it are refused",
+	},
+	cli.StringSliceFlag{
+		Name:  "bt-allowed-peer",
+		Usage: "IPv4 subnet (CIDR) peers are allowed to connect from, default to everywhere",
+	},
 	cli.IntFlag{
 		Name:  "upload-rate",
 		Usage: "bittorrent upload rate limit",
@@ -61,19 +168,110 @@ var daemonFlags = []cli.Flag{
 		Name:  "download-rate",
 		Usage: "bittorrent download rate limit",
 	},
+	cli.StringFlag{
+		Name:  "zone",
+		Usage: "zone of the node, peers of the same zone are preferred",
+	},
+	cli.StringFlag{
+		Name:  "rack",
+		Usage: "rack of the node in its zone, peers of the same rack are preferred",
+	},
+	cli.IntFlag{
+		Name:  "cross-zone-upload-rate",
+		Usage: "upload rate limit to peers of other zones",
+	},
+	cli.IntFlag{
+		Name:  "cross-zone-download-rate",
+		Usage: "download rate limit from peers of other zones",
+	},
+	cli.StringFlag{
+		Name:  "webseed-addr",
+		Usage: "host:port on which the layers will be served to web seed clients, disabled if empty",
+	},
+	cli.StringFlag{
+		Name:  "webseed-url",
+		Usage: "base URL of the web seed advertised in the torrents, http://<webseed-addr> if empty",
+	},
+	cli.IntFlag{
+		Name:  "webseed-rate",
+		Usage: "rate limit of the pieces fetched from web seeds",
+	},
 	cli.StringFlag{
 		Name:  "listen,l",
-		Value: defaultGRPCEndpoint,
+		Value: daemon.DefaultListen,
 		Usage: "proto://address on which the GRPC API will listen",
 	},
 	cli.DurationFlag{
 		Name:  "conn-timeout",
-		Value: 1 * time.Second,
+		Value: daemon.DefaultConnTimeout,
 		Usage: "GRPC connection timeout",
 	},
 	cli.BoolFlag{
 		Name:  "hardlink",
-		Usage: "use hard link to copy layer between oci engine and bt engine",
+		Usage: "use hard link to copy layer between oci engine and bt engine, same as --copy-mode=hardlink",
+	},
+	cli.StringFlag{
+		Name:  "copy-mode",
+		Usage: "copy layers between oci engine and bt engine with auto, reflink, hardlink or copy, falling back to a copy (default copy)",
+	},
+	cli.BoolFlag{
+		Name:  "blob-storage",
+		Usage: "download layers straight into the OCI blobs and seed them from there, without a copy in the bt engine",
+	},
+	cli.StringFlag{
+		Name:  "signature-policy",
+		Usage: "path to a signature verification policy file, default /etc/containers/policy.json",
+	},
+	cli.StringFlag{
+		Name:  "tlscacert",
+		Usage: "trust certs signed only by this CA, required by tcp listeners and seeders",
+	},
+	cli.StringFlag{
+		Name:  "tlscert",
+		Usage: "path to TLS certificate file",
+	},
+	cli.StringFlag{
+		Name:  "tlskey",
+		Usage: "path to TLS key file",
+	},
+	cli.StringFlag{
+		Name:  "authz-policy",
+		Usage: "path to the GRPC API authorization policy file",
+	},
+	cli.IntFlag{
+		Name:  "max-active-torrents",
+		Value: daemon.DefaultMaxActiveTorrents,
+		Usage: "number of layers downloaded at once with torrents by all pulls",
+	},
+	cli.IntFlag{
+		Name:  "max-registry-fetches",
+		Value: daemon.DefaultMaxRegistryFetches,
+		Usage: "number of layers fetched at once from registries by all pulls",
+	},
+	cli.StringFlag{
+		Name:  "layer-order",
+		Value: daemon.LayerOrderSize,
+		Usage: "download the layers of an image smaller first (size) or base first (base)",
+	},
+	cli.StringFlag{
+		Name:  "metrics-addr",
+		Usage: "host:port on which the Prometheus metrics will be served, disabled if empty",
+	},
+	cli.StringFlag{
+		Name:  "registries-dir",
+		Usage: "directory of the registries configuration",
+	},
+	cli.StringFlag{
+		Name:  "registry-cert-dir",
+		Usage: "directory of the registry client certificates",
+	},
+	cli.BoolTFlag{
+		Name:  "registry-insecure-skip-tls-verify",
+		Usage: "do not verify the TLS certificates of registries",
+	},
+	cli.BoolFlag{
+		Name:  "registry-fallback",
+		Usage: "pull the layers from the registry when leeching them fails",
 	},
 }
 
@@ -116,9 +314,6 @@ func main() {
 	app.Flags = daemonFlags
 	app.Before = func(context *cli.Context) error {
 		setupDumpStacksTrap()
-		if context.GlobalBool("debug") {
-			logrus.SetLevel(logrus.DebugLevel)
-		}
 		return nil
 	}
 
@@ -133,36 +328,47 @@ func main() {
 }
 
 func runDaemon(context *cli.Context) error {
-	config := &daemon.Config{
-		Root:              context.String("root-dir"),
-		ConnTimeout:       context.Duration("conn-timeout"),
-		BtEnable:          !context.Bool("disable-bt"),
-		BtSeeder:          context.Bool("bt-seeder"),
-		BtTrackers:        context.StringSlice("bt-tracker"),
-		BtSeederServer:    context.StringSlice("seeder-addr"),
-		UploadRateLimit:   context.Int("upload-rate"),
-		DownloadRateLimit: context.Int("download-rate"),
-		UseHardlink:       context.Bool("hardlink"),
+	config, err := loadConfig(context)
+	if err != nil {
+		return err
 	}
+	level, _ := logrus.ParseLevel(config.LogLevel)
+	logrus.SetLevel(level)
+
 	s := make(chan os.Signal, 2048)
-	signal.Notify(s, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(s, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	be, err := daemon.NewDaemon(config)
 	if err != nil {
 		return err
 	}
 
 	// Split the listen string of the form proto://addr
-	listenSpec := context.String("listen")
-	listenParts := strings.SplitN(listenSpec, "://", 2)
-	if len(listenParts) != 2 {
-		return fmt.Errorf("bad listen address format %s, expected proto://address", listenSpec)
-	}
-	server, err := startServer(listenParts[0], listenParts[1], be)
+	listenParts := strings.SplitN(config.Listen, "://", 2)
+	server, err := startServer(listenParts[0], listenParts[1], config, be)
 	if err != nil {
 		return err
 	}
+	if config.MetricsAddr != "" {
+		if err = startMetricsServer(config.MetricsAddr); err != nil {
+			return err
+		}
+	}
+	if config.WebSeedAddr != "" {
+		if err = startWebSeedServer(config.WebSeedAddr, be); err != nil {
+			return err
+		}
+	}
 	for ss := range s {
 		switch ss {
+		case syscall.SIGHUP:
+			logrus.Infof("reloading configuration after receiving %s", ss)
+			newConfig, err := loadConfig(context)
+			if err == nil {
+				err = be.Reload(newConfig)
+			}
+			if err != nil {
+				logrus.Errorf("reload configuration failed: %v", err)
+			}
 		default:
 			logrus.Infof("stopping server after receiving %s", ss)
 			server.Stop()
@@ -172,7 +378,213 @@ func runDaemon(context *cli.Context) error {
 	return nil
 }
 
-func startServer(protocol, address string, be *daemon.Daemon) (*grpc.Server, error) {
+// loadConfig builds the daemon configuration from the defaults, the
+// configuration file and the flags set on the command line, in that order
+func loadConfig(context *cli.Context) (*daemon.Config, error) {
+	config := daemon.NewConfig()
+	if path := context.String("config"); path != "" {
+		if err := daemon.LoadConfigFile(path, config); err != nil {
+			return nil, err
+		}
+	}
+
+	if context.IsSet("debug") && context.Bool("debug") {
+		config.LogLevel = "debug"
+	}
+	if context.IsSet("log-level") {
+		config.LogLevel = context.String("log-level")
+	}
+	if context.IsSet("root-dir") {
+		config.Root = context.String("root-dir")
+	}
+	if context.IsSet("listen") || context.IsSet("l") {
+		config.Listen = context.String("listen")
+	}
+	if context.IsSet("conn-timeout") {
+		config.ConnTimeout = context.Duration("conn-timeout")
+	}
+	if context.IsSet("hardlink") {
+		config.UseHardlink = context.Bool("hardlink")
+	}
+	if context.IsSet("copy-mode") {
+		config.CopyStrategy = context.String("copy-mode")
+	}
+	if context.IsSet("blob-storage") {
+		config.BlobStorage = context.Bool("blob-storage")
+	}
+	if context.IsSet("signature-policy") {
+		config.SignaturePolicy = context.String("signature-policy")
+	}
+	if context.IsSet("tlscacert") {
+		config.TLSCACert = context.String("tlscacert")
+	}
+	if context.IsSet("tlscert") {
+		config.TLSCert = context.String("tlscert")
+	}
+	if context.IsSet("tlskey") {
+		config.TLSKey = context.String("tlskey")
+	}
+	if context.IsSet("authz-policy") {
+		config.AuthzPolicy = context.String("authz-policy")
+	}
+	if context.IsSet("max-active-torrents") {
+		config.MaxActiveTorrents = context.Int("max-active-torrents")
+	}
+	if context.IsSet("max-registry-fetches") {
+		config.MaxRegistryFetches = context.Int("max-registry-fetches")
+	}
+	if context.IsSet("layer-order") {
+		config.LayerOrder = context.String("layer-order")
+	}
+	if context.IsSet("metrics-addr") {
+		config.MetricsAddr = context.String("metrics-addr")
+	}
+	if context.IsSet("registries-dir") {
+		config.RegistriesDirPath = context.String("registries-dir")
+	}
+	if context.IsSet("registry-cert-dir") {
+		config.RegistryCertPath = context.String("registry-cert-dir")
+	}
+	if context.IsSet("registry-insecure-skip-tls-verify") {
+		config.RegistryInsecureSkipVerify = context.BoolT("registry-insecure-skip-tls-verify")
+	}
+	if context.IsSet("registry-fallback") {
+		config.RegistryFallback = context.Bool("registry-fallback")
+	}
+	if context.IsSet("disable-bt") {
+		config.BtEnable = !context.Bool("disable-bt")
+	}
+	if context.IsSet("bt-seeder") {
+		config.BtSeeder = context.Bool("bt-seeder")
+	}
+	if context.IsSet("bt-tracker") {
+		config.BtTrackers = context.StringSlice("bt-tracker")
+	}
+	if context.IsSet("seeder-addr") {
+		config.BtSeederServer = context.StringSlice("seeder-addr")
+	}
+	if context.IsSet("bt-port") {
+		config.BtIncomingPort = context.Int("bt-port")
+	}
+	if context.IsSet("bt-disable-encryption") {
+		config.BtDisableEncryption = context.BoolT("bt-disable-encryption")
+	}
+	if context.IsSet("bt-max-port") {
+		config.BtMaxPort = context.Int("bt-max-port")
+	}
+	if context.IsSet("bt-listen-host") {
+		config.BtListenHost = context.String("bt-listen-host")
+	}
+	if context.IsSet("bt-encryption") {
+		config.BtEncryption = context.String("bt-encryption")
+	}
+	if context.IsSet("bt-disable-tcp") {
+		config.BtDisableTCP = context.Bool("bt-disable-tcp")
+	}
+	if context.IsSet("bt-disable-utp") {
+		config.BtDisableUTP = context.BoolT("bt-disable-utp")
+	}
+	if context.IsSet("bt-piece-length") {
+		config.BtPieceLength = int64(context.Int("bt-piece-length"))
+	}
+	if context.IsSet("bt-min-piece-length") {
+		config.BtMinPieceLength = int64(context.Int("bt-min-piece-length"))
+	}
+	if context.IsSet("bt-max-piece-length") {
+		config.BtMaxPieceLength = int64(context.Int("bt-max-piece-length"))
+	}
+	if context.IsSet("bt-target-pieces") {
+		config.BtTargetPieces = int64(context.Int("bt-target-pieces"))
+	}
+	if context.IsSet("verify-on-start") {
+		config.VerifyOnStart = context.Bool("verify-on-start")
+	}
+	if context.IsSet("bt-load-concurrency") {
+		config.BtLoadConcurrency = context.Int("bt-load-concurrency")
+	}
+	if context.IsSet("bt-dht") {
+		config.BtDHT = context.Bool("bt-dht")
+	}
+	if context.IsSet("bt-dht-node") {
+		config.BtDHTNodes = context.StringSlice("bt-dht-node")
+	}
+	if context.IsSet("bt-lsd") {
+		config.BtLSD = context.Bool("bt-lsd")
+	}
+	if context.IsSet("bt-lsd-interface") {
+		config.BtLSDInterfaces = context.StringSlice("bt-lsd-interface")
+	}
+	if context.IsSet("bt-magnet") {
+		config.BtMagnet = context.Bool("bt-magnet")
+	}
+	if context.IsSet("bt-image-torrent") {
+		config.BtImageTorrent = context.Bool("bt-image-torrent")
+	}
+	if context.IsSet("bt-private") {
+		config.BtPrivate = context.Bool("bt-private")
+	}
+	if context.IsSet("bt-peer-secret-file") {
+		config.BtPeerSecretFile = context.String("bt-peer-secret-file")
+	}
+	if context.IsSet("bt-allowed-peer") {
+		config.BtAllowedPeers = context.StringSlice("bt-allowed-peer")
+	}
+	if context.IsSet("upload-rate") {
+		config.UploadRateLimit = context.Int("upload-rate")
+	}
+	if context.IsSet("download-rate") {
+		config.DownloadRateLimit = context.Int("download-rate")
+	}
+	if context.IsSet("zone") {
+		config.Zone = context.String("zone")
+	}
+	if context.IsSet("rack") {
+		config.Rack = context.String("rack")
+	}
+	if context.IsSet("cross-zone-upload-rate") {
+		config.CrossZoneUploadRateLimit = context.Int("cross-zone-upload-rate")
+	}
+	if context.IsSet("cross-zone-download-rate") {
+		config.CrossZoneDownloadRateLimit = context.Int("cross-zone-download-rate")
+	}
+	if context.IsSet("webseed-addr") {
+		config.WebSeedAddr = context.String("webseed-addr")
+	}
+	if context.IsSet("webseed-url") {
+		config.WebSeedURL = context.String("webseed-url")
+	}
+	if context.IsSet("webseed-rate") {
+		config.WebSeedRateLimit = context.Int("webseed-rate")
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid configuration: %v", err)
+	}
+	return config, nil
+}
+
+func startServer(protocol, address string, config *daemon.Config, be *daemon.Daemon) (*grpc.Server, error) {
+	var serverOpts []grpc.ServerOption
+	if protocol == "tcp" {
+		creds, err := serverCredentials(config)
+		if err != nil {
+			return nil, err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+	interceptors := []grpc.UnaryServerInterceptor{server.MetricsInterceptor}
+	if config.AuthzPolicy != "" {
+		authz, err := server.NewAuthorizerFromFile(config.AuthzPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if protocol == "unix" {
+			serverOpts = append(serverOpts, grpc.Creds(server.NewUnixCredentials()))
+		}
+		interceptors = append(interceptors, authz.UnaryInterceptor)
+	}
+	serverOpts = append(serverOpts, grpc.UnaryInterceptor(server.ChainUnaryInterceptors(interceptors...)))
+
 	sockets, err := listeners.Init(protocol, address, "", nil)
 	if err != nil {
 		return nil, err
@@ -181,7 +593,7 @@ func startServer(protocol, address string, be *daemon.Daemon) (*grpc.Server, err
 		return nil, fmt.Errorf("incorrect number of listeners")
 	}
 	l := sockets[0]
-	s := grpc.NewServer()
+	s := grpc.NewServer(serverOpts...)
 	types.RegisterAPIServer(s, server.NewServer(be))
 
 	go func() {
@@ -192,3 +604,56 @@ func startServer(protocol, address string, be *daemon.Daemon) (*grpc.Server, err
 	}()
 	return s, nil
 }
+
+// startMetricsServer serves the Prometheus metrics on address
+func startMetricsServer(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Listen metrics address %s failed: %v", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		logrus.Debugf("oci-torrentd: metrics on %s", address)
+		if err := http.Serve(l, mux); err != nil {
+			logrus.WithField("error", err).Fatal("oci-torrentd: serve metrics")
+		}
+	}()
+	return nil
+}
+
+// startWebSeedServer serves the layers of the daemon to web seed clients
+// on address
+func startWebSeedServer(address string, be *daemon.Daemon) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("Listen web seed address %s failed: %v", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(bt.WebSeedPath, be.WebSeedHandler())
+	go func() {
+		logrus.Debugf("oci-torrentd: web seed on %s", address)
+		if err := http.Serve(l, mux); err != nil {
+			logrus.WithField("error", err).Fatal("oci-torrentd: serve web seed")
+		}
+	}()
+	return nil
+}
+
+// serverCredentials returns the TLS credentials of TCP listeners, which
+// require and verify client certificates
+func serverCredentials(config *daemon.Config) (credentials.TransportCredentials, error) {
+	if config.TLSCACert == "" || config.TLSCert == "" || config.TLSKey == "" {
+		return nil, fmt.Errorf("tcp listener requires --tlscacert, --tlscert and --tlskey")
+	}
+	tlsConfig, err := tlsconfig.Server(tlsconfig.Options{
+		CAFile:     config.TLSCACert,
+		CertFile:   config.TLSCert,
+		KeyFile:    config.TLSKey,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
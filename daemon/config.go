@@ -1,19 +1,418 @@
 package daemon
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
 	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/hustcat/oci-torrent/bt"
+	"github.com/hustcat/oci-torrent/utils"
+)
+
+const (
+	DefaultRoot        = "/data/oci-torrentd"
+	DefaultListen      = "unix:///run/oci-torrentd/oci-torrentd.sock"
+	DefaultConnTimeout = 1 * time.Second
+	DefaultBtPort      = 50007
+	minPieceLength     = 16 * 1024
+	DefaultLogLevel    = "info"
 )
 
+// Config is the configuration of the daemon. The keys of the configuration
+// file are the names of the corresponding command line flags.
 type Config struct {
-	Pidfile     string
-	Root        string
-	ConnTimeout time.Duration
-	UseHardlink bool
-
-	BtEnable          bool
-	BtSeeder          bool
-	BtTrackers        []string
-	BtSeederServer    []string
-	UploadRateLimit   int
-	DownloadRateLimit int
+	Pidfile     string        `json:"pidfile,omitempty"`
+	Root        string        `json:"root-dir,omitempty"`
+	Listen      string        `json:"listen,omitempty"`
+	ConnTimeout time.Duration `json:"-"`
+	UseHardlink bool          `json:"hardlink,omitempty"`
+	LogLevel    string        `json:"log-level,omitempty"`
+
+	// Strategy copying layers between the OCI directory and the bt
+	// engine, see utils.CopyMode
+	CopyStrategy string `json:"copy-mode,omitempty"`
+
+	// Download layers into, and seed them from, the blobs of the OCI
+	// directories instead of the bt data directory
+	BlobStorage bool `json:"blob-storage,omitempty"`
+
+	// Hash the pieces of the torrents again at startup instead of trusting
+	// their recorded completion, and number of torrents loaded at once
+	VerifyOnStart     bool `json:"verify-on-start,omitempty"`
+	BtLoadConcurrency int  `json:"bt-load-concurrency,omitempty"`
+
+	// Layers downloaded at once by all pulls with torrents and from
+	// registries, and default order of the layers of a pull
+	MaxActiveTorrents  int    `json:"max-active-torrents,omitempty"`
+	MaxRegistryFetches int    `json:"max-registry-fetches,omitempty"`
+	LayerOrder         string `json:"layer-order,omitempty"`
+
+	// Path to the signature policy file, use the system default if empty
+	SignaturePolicy string `json:"signature-policy,omitempty"`
+
+	// TLS files, required by TCP listeners and for talking to TCP seeders
+	TLSCACert string `json:"tlscacert,omitempty"`
+	TLSCert   string `json:"tlscert,omitempty"`
+	TLSKey    string `json:"tlskey,omitempty"`
+
+	// Path to the authorization policy of the GRPC API, allow all if empty
+	AuthzPolicy string `json:"authz-policy,omitempty"`
+
+	// host:port of the Prometheus metrics listener, disabled if empty
+	MetricsAddr string `json:"metrics-addr,omitempty"`
+
+	// Registry settings
+	RegistriesDirPath          string `json:"registries-dir,omitempty"`
+	RegistryCertPath           string `json:"registry-cert-dir,omitempty"`
+	RegistryInsecureSkipVerify bool   `json:"registry-insecure-skip-tls-verify"`
+	// Pull the layers from the registry when leeching them fails
+	RegistryFallback bool `json:"registry-fallback,omitempty"`
+
+	BtEnable            bool     `json:"-"`
+	BtSeeder            bool     `json:"bt-seeder,omitempty"`
+	BtTrackers          []string `json:"bt-tracker,omitempty"`
+	BtSeederServer      []string `json:"seeder-addr,omitempty"`
+	BtIncomingPort      int      `json:"bt-port,omitempty"`
+	BtMaxPort           int      `json:"bt-max-port,omitempty"`
+	BtListenHost        string   `json:"bt-listen-host,omitempty"`
+	BtDisableEncryption bool     `json:"bt-disable-encryption"`
+	BtEncryption        string   `json:"bt-encryption,omitempty"`
+	BtDisableTCP        bool     `json:"bt-disable-tcp,omitempty"`
+	BtDisableUTP        bool     `json:"bt-disable-utp"`
+	BtPieceLength       int64    `json:"bt-piece-length,omitempty"`
+	BtMinPieceLength    int64    `json:"bt-min-piece-length,omitempty"`
+	BtMaxPieceLength    int64    `json:"bt-max-piece-length,omitempty"`
+	BtTargetPieces      int64    `json:"bt-target-pieces,omitempty"`
+	BtDHT               bool     `json:"bt-dht,omitempty"`
+	BtDHTNodes          []string `json:"bt-dht-node,omitempty"`
+	BtLSD               bool     `json:"bt-lsd,omitempty"`
+	BtLSDInterfaces     []string `json:"bt-lsd-interface,omitempty"`
+	BtMagnet            bool     `json:"bt-magnet,omitempty"`
+	BtImageTorrent      bool     `json:"bt-image-torrent,omitempty"`
+	BtPrivate           bool     `json:"bt-private,omitempty"`
+	BtPeerSecretFile    string   `json:"bt-peer-secret-file,omitempty"`
+	BtAllowedPeers      []string `json:"bt-allowed-peer,omitempty"`
+	UploadRateLimit     int      `json:"upload-rate,omitempty"`
+	DownloadRateLimit   int      `json:"download-rate,omitempty"`
+
+	// Topology labels of the node, and rate limits of the traffic with
+	// nodes of other zones
+	Zone                       string `json:"zone,omitempty"`
+	Rack                       string `json:"rack,omitempty"`
+	CrossZoneUploadRateLimit   int    `json:"cross-zone-upload-rate,omitempty"`
+	CrossZoneDownloadRateLimit int    `json:"cross-zone-download-rate,omitempty"`
+
+	// host:port of the HTTP listener serving the layers to web seed
+	// clients, the base URL advertised in the torrents, by default
+	// http://<webseed-addr>, and the rate limit of the pieces fetched from
+	// web seeds
+	WebSeedAddr      string `json:"webseed-addr,omitempty"`
+	WebSeedURL       string `json:"webseed-url,omitempty"`
+	WebSeedRateLimit int    `json:"webseed-rate,omitempty"`
+
+	// Global rate limits by time of day, the first matching window wins
+	// and the rates above apply outside of any window
+	BandwidthSchedule []BandwidthWindow `json:"bandwidth-schedule,omitempty"`
+}
+
+// NewConfig returns a configuration with the default values
+func NewConfig() *Config {
+	return &Config{
+		Root:                       DefaultRoot,
+		Listen:                     DefaultListen,
+		ConnTimeout:                DefaultConnTimeout,
+		LogLevel:                   DefaultLogLevel,
+		BtEnable:                   true,
+		BtIncomingPort:             DefaultBtPort,
+		RegistryInsecureSkipVerify: true,
+		BtDisableEncryption:        true,
+		BtDisableUTP:               true,
+		BtMinPieceLength:           bt.DefaultMinPieceLength,
+		BtMaxPieceLength:           bt.DefaultMaxPieceLength,
+		BtTargetPieces:             bt.DefaultTargetPieces,
+		BtLoadConcurrency:          bt.DefaultLoadConcurrency,
+		MaxActiveTorrents:          DefaultMaxActiveTorrents,
+		MaxRegistryFetches:         DefaultMaxRegistryFetches,
+		LayerOrder:                 LayerOrderSize,
+	}
+}
+
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type config Config
+	aux := struct {
+		*config
+		ConnTimeout string `json:"conn-timeout,omitempty"`
+		DisableBt   *bool  `json:"disable-bt,omitempty"`
+	}{config: (*config)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.ConnTimeout != "" {
+		d, err := time.ParseDuration(aux.ConnTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid conn-timeout %q: %v", aux.ConnTimeout, err)
+		}
+		c.ConnTimeout = d
+	}
+	if aux.DisableBt != nil {
+		c.BtEnable = !*aux.DisableBt
+	}
+	return nil
+}
+
+// LoadConfigFile reads the configuration file at path on top of config
+func LoadConfigFile(path string, config *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("Error parsing configuration file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate returns an error describing the first invalid setting
+func (c *Config) Validate() error {
+	if c.Root == "" {
+		return fmt.Errorf("root-dir cannot be empty")
+	}
+	if err := validateAddress("listen", c.Listen); err != nil {
+		return err
+	}
+	if c.ConnTimeout <= 0 {
+		return fmt.Errorf("conn-timeout must be positive, got %s", c.ConnTimeout)
+	}
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("invalid log-level %q", c.LogLevel)
+	}
+	if c.CopyStrategy != "" {
+		if _, err := utils.ParseCopyMode(c.CopyStrategy); err != nil {
+			return fmt.Errorf("invalid copy-mode: %v", err)
+		}
+	}
+
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return fmt.Errorf("bad metrics-addr %s: %v", c.MetricsAddr, err)
+		}
+	}
+
+	tlsFiles := 0
+	for _, f := range []string{c.TLSCACert, c.TLSCert, c.TLSKey} {
+		if f != "" {
+			tlsFiles++
+		}
+	}
+	if tlsFiles != 0 && tlsFiles != 3 {
+		return fmt.Errorf("tlscacert, tlscert and tlskey must be set together")
+	}
+
+	for _, t := range c.BtTrackers {
+		u, err := url.Parse(t)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid bt-tracker URL %q", t)
+		}
+	}
+	if c.BtEnable && c.BtSeeder && len(c.BtTrackers) == 0 && !c.BtDHT {
+		return fmt.Errorf("bt-seeder requires at least one bt-tracker or bt-dht")
+	}
+	if c.BtPrivate && c.BtDHT && len(c.BtTrackers) == 0 {
+		// Private torrents are never announced on the DHT
+		return fmt.Errorf("bt-private with bt-dht requires at least one bt-tracker")
+	}
+	for _, n := range c.BtDHTNodes {
+		if _, _, err := net.SplitHostPort(n); err != nil {
+			return fmt.Errorf("bad bt-dht-node %s, expected host:port", n)
+		}
+	}
+	for _, s := range c.BtSeederServer {
+		if err := validateAddress("seeder-addr", s); err != nil {
+			return err
+		}
+	}
+	if c.BtIncomingPort <= 0 || c.BtIncomingPort > 65535 {
+		return fmt.Errorf("bt-port must be between 1 and 65535, got %d", c.BtIncomingPort)
+	}
+	if c.BtMaxPort != 0 && (c.BtMaxPort < c.BtIncomingPort || c.BtMaxPort > 65535) {
+		return fmt.Errorf("bt-max-port must be between bt-port and 65535, got %d", c.BtMaxPort)
+	}
+	switch c.BtEncryption {
+	case "", bt.EncryptionDisabled, bt.EncryptionPreferred, bt.EncryptionRequired:
+	default:
+		return fmt.Errorf("bt-encryption must be %s, %s or %s, got %q",
+			bt.EncryptionDisabled, bt.EncryptionPreferred, bt.EncryptionRequired, c.BtEncryption)
+	}
+	if c.BtDisableTCP && c.BtDisableUTP {
+		return fmt.Errorf("bt-disable-tcp and bt-disable-utp cannot be both set")
+	}
+	if c.BtPieceLength != 0 {
+		if err := validatePieceLength("bt-piece-length", c.BtPieceLength); err != nil {
+			return err
+		}
+	}
+	if err := validatePieceLength("bt-min-piece-length", c.BtMinPieceLength); err != nil {
+		return err
+	}
+	if err := validatePieceLength("bt-max-piece-length", c.BtMaxPieceLength); err != nil {
+		return err
+	}
+	if c.BtMaxPieceLength < c.BtMinPieceLength {
+		return fmt.Errorf("bt-max-piece-length cannot be below bt-min-piece-length")
+	}
+	if c.BtTargetPieces <= 0 {
+		return fmt.Errorf("bt-target-pieces must be positive, got %d", c.BtTargetPieces)
+	}
+	if c.BtLoadConcurrency <= 0 {
+		return fmt.Errorf("bt-load-concurrency must be positive, got %d", c.BtLoadConcurrency)
+	}
+	if c.MaxActiveTorrents <= 0 {
+		return fmt.Errorf("max-active-torrents must be positive, got %d", c.MaxActiveTorrents)
+	}
+	if c.MaxRegistryFetches <= 0 {
+		return fmt.Errorf("max-registry-fetches must be positive, got %d", c.MaxRegistryFetches)
+	}
+	if err := validateLayerOrder(c.LayerOrder); err != nil {
+		return err
+	}
+	if c.UploadRateLimit < 0 {
+		return fmt.Errorf("upload-rate cannot be negative, got %d", c.UploadRateLimit)
+	}
+	if c.DownloadRateLimit < 0 {
+		return fmt.Errorf("download-rate cannot be negative, got %d", c.DownloadRateLimit)
+	}
+	if _, err := parseBandwidthSchedule(c.BandwidthSchedule); err != nil {
+		return err
+	}
+	if c.BtPeerSecretFile != "" {
+		if _, err := c.PeerSecret(); err != nil {
+			return err
+		}
+		// The proof of the secret is bound to the encryption handshake
+		if c.Encryption() != bt.EncryptionRequired {
+			return fmt.Errorf("bt-peer-secret-file requires bt-encryption=%s", bt.EncryptionRequired)
+		}
+	}
+	if _, err := bt.ParseAllowedPeers(c.BtAllowedPeers); err != nil {
+		return fmt.Errorf("invalid bt-allowed-peer: %v", err)
+	}
+	if c.Rack != "" && c.Zone == "" {
+		return fmt.Errorf("rack %s requires a zone", c.Rack)
+	}
+	if c.CrossZoneUploadRateLimit < 0 {
+		return fmt.Errorf("cross-zone-upload-rate cannot be negative, got %d", c.CrossZoneUploadRateLimit)
+	}
+	if c.CrossZoneDownloadRateLimit < 0 {
+		return fmt.Errorf("cross-zone-download-rate cannot be negative, got %d", c.CrossZoneDownloadRateLimit)
+	}
+	if c.WebSeedAddr != "" {
+		host, _, err := net.SplitHostPort(c.WebSeedAddr)
+		if err != nil {
+			return fmt.Errorf("bad webseed-addr %s: %v", c.WebSeedAddr, err)
+		}
+		if ip := net.ParseIP(host); c.WebSeedURL == "" && (host == "" || ip != nil && ip.IsUnspecified()) {
+			return fmt.Errorf("webseed-addr %s has no host, webseed-url is required", c.WebSeedAddr)
+		}
+		// The web seed has no peer authentication, only the subnets of
+		// the allowed peers keep the layers of a private swarm private
+		if (c.BtPrivate || c.BtPeerSecretFile != "") && len(c.BtAllowedPeers) == 0 {
+			return fmt.Errorf("webseed-addr with bt-private or bt-peer-secret-file requires bt-allowed-peer")
+		}
+	}
+	if c.WebSeedURL != "" {
+		u, err := url.Parse(c.WebSeedURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webseed-url %q", c.WebSeedURL)
+		}
+	}
+	if c.WebSeedRateLimit < 0 {
+		return fmt.Errorf("webseed-rate cannot be negative, got %d", c.WebSeedRateLimit)
+	}
+	return nil
+}
+
+// WebSeeds returns the web seed URLs to embed in the torrents, none if
+// the daemon does not serve the layers over HTTP
+func (c *Config) WebSeeds() []string {
+	if c.WebSeedAddr == "" {
+		return nil
+	}
+	base := c.WebSeedURL
+	if base == "" {
+		base = "http://" + c.WebSeedAddr
+	}
+	return []string{strings.TrimSuffix(base, "/") + bt.WebSeedPath}
+}
+
+func validatePieceLength(name string, l int64) error {
+	if l < minPieceLength || l&(l-1) != 0 {
+		return fmt.Errorf("%s must be a power of two of at least %d, got %d", name, minPieceLength, l)
+	}
+	return nil
+}
+
+// CopyMode returns the strategy copying layers, copy-mode or else hardlink
+// or copy depending on hardlink
+func (c *Config) CopyMode() utils.CopyMode {
+	if c.CopyStrategy != "" {
+		return utils.CopyMode(c.CopyStrategy)
+	}
+	if c.UseHardlink {
+		return utils.CopyHardlink
+	}
+	return utils.CopyStream
+}
+
+// Encryption returns the encryption mode of the peer wire, bt-encryption
+// or else disable or prefer depending on bt-disable-encryption
+func (c *Config) Encryption() string {
+	if c.BtEncryption != "" {
+		return c.BtEncryption
+	}
+	if c.BtDisableEncryption {
+		return bt.EncryptionDisabled
+	}
+	return bt.EncryptionPreferred
+}
+
+// PeerSecret returns the content of the peer secret file, without the
+// surrounding white spaces
+func (c *Config) PeerSecret() (string, error) {
+	if c.BtPeerSecretFile == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(c.BtPeerSecretFile)
+	if err != nil {
+		return "", fmt.Errorf("Read bt-peer-secret-file failed: %v", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("bt-peer-secret-file %s is empty", c.BtPeerSecretFile)
+	}
+	return secret, nil
+}
+
+// validateAddress checks a proto://address setting
+func validateAddress(name, address string) error {
+	parts := strings.SplitN(address, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return fmt.Errorf("bad %s address format %s, expected proto://address", name, address)
+	}
+	switch parts[0] {
+	case "unix":
+	case "tcp":
+		if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+			return fmt.Errorf("bad %s address %s: %v", name, address, err)
+		}
+	default:
+		return fmt.Errorf("bad %s address %s, protocol must be unix or tcp", name, address)
+	}
+	return nil
 }
@@ -2,18 +2,23 @@ package daemon
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"syscall"
 
 	log "github.com/Sirupsen/logrus"
 	distdigests "github.com/docker/distribution/digest"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 
 	"github.com/containers/image/docker/reference"
-	"github.com/containers/image/signature"
 	"github.com/containers/image/transports"
 	imagetypes "github.com/containers/image/types"
 
@@ -25,10 +30,32 @@ import (
 const (
 	usernameKey = "username"
 	passwordKey = "password"
+
+	// Peers returned to leechers with the torrent of a layer
+	maxHandoffPeers = 30
 )
 
 type Daemon struct {
-	config *Config
+	configLock sync.RWMutex
+	config     *Config
+
+	// The image torrents of the images pulled and the active bandwidth
+	// window
+	limitLock       sync.Mutex
+	imageTorrents   map[string]string
+	bandwidthWindow string
+	// Slots of the layers downloaded by all pulls
+	scheduler *layerScheduler
+	// Pulls of images and layers in progress, joined by the later ones
+	imagePulls *flightGroup
+	layerPulls *flightGroup
+	// Connections to the GRPC API of the seeders by address
+	remoteLock sync.Mutex
+	remotes    map[string]*grpc.ClientConn
+	remoteTLS  string // fingerprint of the TLS files of remotes
+	// Status of the torrents exported by the metrics, refreshed before
+	// each collection
+	torrentStats []bt.Status
 	// BT engine
 	btEngine *bt.BtEngine
 }
@@ -46,13 +73,43 @@ func NewDaemon(config *Config) (*Daemon, error) {
 		return nil, err
 	}
 
+	secret, err := config.PeerSecret()
+	if err != nil {
+		return nil, err
+	}
+
 	c := &bt.Config{
-		DisableEncryption: true,
+		DisableEncryption: config.Encryption() == bt.EncryptionDisabled,
+		RequireEncryption: config.Encryption() == bt.EncryptionRequired,
+		DisableTCP:        config.BtDisableTCP,
+		DisableUTP:        config.BtDisableUTP,
+		ListenHost:        config.BtListenHost,
+		MaxPort:           config.BtMaxPort,
 		EnableUpload:      true,
 		EnableSeeding:     true,
-		IncomingPort:      50007,
+		IncomingPort:      config.BtIncomingPort,
+		PieceLength:       config.BtPieceLength,
+		MinPieceLength:    config.BtMinPieceLength,
+		MaxPieceLength:    config.BtMaxPieceLength,
+		TargetPieces:      config.BtTargetPieces,
 		UploadRateLimit:   config.UploadRateLimit,
 		DownloadRateLimit: config.DownloadRateLimit,
+		EnableDHT:         config.BtDHT,
+		DHTNodes:          config.BtDHTNodes,
+		EnableLSD:         config.BtLSD,
+		LSDInterfaces:     config.BtLSDInterfaces,
+		Zone:              config.Zone,
+		Rack:              config.Rack,
+
+		CrossZoneUploadRateLimit:   config.CrossZoneUploadRateLimit,
+		CrossZoneDownloadRateLimit: config.CrossZoneDownloadRateLimit,
+		Private:                    config.BtPrivate,
+		PeerSecret:                 secret,
+		AllowedPeers:               config.BtAllowedPeers,
+		WebSeeds:                   config.WebSeeds(),
+		WebSeedRateLimit:           config.WebSeedRateLimit,
+		VerifyOnStart:              config.VerifyOnStart,
+		LoadConcurrency:            config.BtLoadConcurrency,
 	}
 	btEngine := bt.NewBtEngine(btRoot, config.BtTrackers, c)
 	if config.BtEnable {
@@ -65,46 +122,125 @@ func NewDaemon(config *Config) (*Daemon, error) {
 	daemon := &Daemon{
 		config:   config,
 		btEngine: btEngine,
+
+		imageTorrents: map[string]string{},
+		scheduler:     newLayerScheduler(config.MaxActiveTorrents, config.MaxRegistryFetches),
+		imagePulls:    newFlightGroup(),
+		layerPulls:    newFlightGroup(),
+		remotes:       map[string]*grpc.ClientConn{},
+		remoteTLS:     tlsFingerprint(config),
+	}
+	daemon.registerMetrics()
+	if config.MetricsAddr != "" {
+		go daemon.runDiskUsage()
+	}
+	if config.BtEnable {
+		go daemon.runBandwidthSchedule()
+	}
+	if config.BtEnable && config.BtDHT && len(config.BtDHTNodes) == 0 {
+		go daemon.resolveDHTNodes(config.BtSeederServer)
 	}
 	return daemon, nil
 }
 
 func (daemon *Daemon) StartDownload(ctx context.Context, r *types.StartDownloadRequest) (*types.StartDownloadResponse, error) {
 	if r.Username != "" && r.Password != "" {
-		context.WithValue(ctx, usernameKey, r.Username)
-		context.WithValue(ctx, passwordKey, r.Password)
+		ctx = context.WithValue(ctx, usernameKey, r.Username)
+		ctx = context.WithValue(ctx, passwordKey, r.Password)
 	}
 
 	var (
-		reportWriter io.WriteCloser
+		reportWriter io.Writer
 		err          error
 	)
 	if r.Stdout != "" {
-		reportWriter, err = os.OpenFile(r.Stdout, syscall.O_WRONLY, 0)
+		f, err := os.OpenFile(r.Stdout, syscall.O_WRONLY, 0)
 		if err != nil {
 			return nil, err
 		}
-		defer func() {
-			reportWriter.Close()
-		}()
+		defer f.Close()
+		reportWriter = &syncWriter{w: f}
 	}
 	writeReport := func(f string, a ...interface{}) {
 		if reportWriter != nil {
 			fmt.Fprintf(reportWriter, f, a...)
 		}
 	}
+	if err = validateLayerOrder(r.LayerOrder); err != nil {
+		return nil, err
+	}
 
-	if daemon.config.BtSeeder {
-		return daemon.startSeederDownload(ctx, r.Source, reportWriter, writeReport)
-	} else {
-		return daemon.startLeecherDownload(ctx, r.Source, reportWriter, writeReport)
+	// A pull of an image in progress with the same request is joined, it
+	// gets the same result and report. It is cancelled when all the clients
+	// waiting for it went away.
+	key := r.Source
+	if ref, err := transports.ParseImageName(r.Source); err == nil {
+		key = transports.ImageName(ref)
+	}
+	_, err, _ = daemon.imagePulls.do(ctx, pullKey(key, r), reportWriter, func(ctx context.Context, reportWriter io.Writer) (string, error) {
+		// Layers are downloaded concurrently, a report line is written at
+		// once
+		writeReport := func(f string, a ...interface{}) {
+			fmt.Fprintf(reportWriter, f, a...)
+		}
+		timer := newPullTimer(r.Source)
+		var err error
+		if daemon.getConfig().BtSeeder {
+			_, err = daemon.startSeederDownload(ctx, r, timer, reportWriter, writeReport)
+		} else {
+			_, err = daemon.startLeecherDownload(ctx, r, timer, reportWriter, writeReport)
+		}
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		timer.done(err)
+		return "", err
+	}, func() {
+		log.Infof("Join the pull of %s in progress", key)
+		writeReport("Waiting for the pull of %s in progress\n", key)
+		pullsJoined.Inc("image")
+	})
+	if err != nil {
+		if cerr := ctx.Err(); cerr != nil {
+			log.Infof("Pull of %s cancelled: %v", key, cerr)
+			writeReport("Pull of %s cancelled: %v\n", key, cerr)
+			code := codes.Canceled
+			if cerr == context.DeadlineExceeded {
+				code = codes.DeadlineExceeded
+			}
+			return nil, grpc.Errorf(code, "Pull of %s cancelled: %v", key, cerr)
+		}
+		return nil, err
+	}
+	return &types.StartDownloadResponse{}, nil
+}
+
+// pullKey returns the key of the pulls of the image named key which can
+// share a pull of r: those with the same credentials and layer order
+func pullKey(key string, r *types.StartDownloadRequest) string {
+	h := sha256.New()
+	for _, s := range append([]string{r.Username, r.Password, r.LayerOrder}, r.PriorityLayers...) {
+		fmt.Fprintf(h, "%d:%s", len(s), s)
 	}
+	return fmt.Sprintf("%s@%x", key, h.Sum(nil))
+}
+
+// syncWriter serializes the writes of the concurrent layers of a pull
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
 }
 
-func (daemon *Daemon) startSeederDownload(ctx context.Context, source string, reportWriter io.Writer, writeReport func(f string, a ...interface{})) (*types.StartDownloadResponse, error) {
+func (daemon *Daemon) startSeederDownload(ctx context.Context, r *types.StartDownloadRequest, timer *pullTimer, reportWriter io.Writer, writeReport func(f string, a ...interface{})) (*types.StartDownloadResponse, error) {
 	sysCtx := daemon.getSystemContext(ctx)
 
-	imageSource := source
+	imageSource := r.Source
 	if imageSource == "" {
 		return nil, fmt.Errorf("Image source can't be nil")
 	}
@@ -115,51 +251,82 @@ func (daemon *Daemon) startSeederDownload(ctx context.Context, source string, re
 	}
 
 	writeReport("Get layer info %s\n", imageSource)
+	stop := timer.phase("manifest")
 	img, err := srcRef.NewImage(sysCtx)
 	if err != nil {
 		return nil, fmt.Errorf("Error new image %v", err)
 	}
+	defer img.Close()
 
-	layerInfos := img.LayerInfos()
-	log.Debugf("layerInfos: %v", layerInfos)
-
-	src, err := srcRef.NewImageSource(sysCtx, ociSupportedManifestMIMETypes())
+	writeReport("Checking signature policy\n")
+	err = daemon.checkImagePolicy(img)
+	stop()
 	if err != nil {
-		return nil, fmt.Errorf("Error initializing source %s: %v", transports.ImageName(srcRef), err)
+		return nil, err
 	}
 
+	layerInfos := img.LayerInfos()
+	log.Debugf("layerInfos: %v", layerInfos)
+
 	ociImg, err := newOciImage(daemon, srcRef)
 	if err != nil {
 		return nil, err
 	}
 	defer ociImg.Close()
 
-	for _, layer := range layerInfos {
-		var ok bool
-		if ok, err = ociImg.layout.Exist(ctx, layer.Digest); err != nil {
-			return nil, fmt.Errorf("Error check OCI dest blob exist: %v", err)
-		}
-
-		if ok {
-			// Layer exist, skip it
-			writeReport("%s exist, skip it\n", layer.Digest)
-			log.Infof("Layer %s exist, skip it", layer.Digest)
-			continue
-		}
-
-		writeReport("Copying layer %s\n", layer.Digest)
-		if err = daemon.copyLayer(ctx, ociImg, src, layer, reportWriter); err != nil {
+	missing, err := daemon.missingLayers(ctx, ociImg, layerInfos, writeReport)
+	if err != nil {
+		return nil, err
+	}
+	pull := daemon.newPull(missing, r)
+	bars, writeReport := layerProgress(missing, reportWriter, writeReport)
+	err = eachLayer(missing, func(layer imagetypes.BlobInfo) error {
+		err := daemon.pullLayer(ctx, srcRef, ociImg, layer, bars(layer.Digest), writeReport, func(ctx context.Context, ociImg *OciImage, w io.Writer) error {
+			release, err := pull.acquire(ctx, registrySlot, layer.Digest)
+			if err != nil {
+				return err
+			}
+			defer release()
+			src, err := srcRef.NewImageSource(daemon.getSystemContext(ctx), ociSupportedManifestMIMETypes())
+			if err != nil {
+				return fmt.Errorf("Error initializing source %s: %v", transports.ImageName(srcRef), err)
+			}
+			defer src.Close()
+			fmt.Fprintf(w, "Copying layer %s\n", layer.Digest)
+			defer timer.phase("registry")()
+			return daemon.copyLayer(ctx, ociImg, src, layer, w)
+		})
+		if err != nil {
 			log.Errorf("Error copy layer %s: %v", layer.Digest, err)
-			return nil, err
+			return err
 		} else {
 			log.Infof("Success copy layer %s", layer.Digest)
 		}
 
+		daemon.trackImageLayer(namedKey(srcRef.DockerReference()), distdigests.Digest(layer.Digest).Hex())
 		log.Debugf("Start seeding layer %s", layer.Digest)
-		err = daemon.startSeedingLayer(ctx, ociImg, layer.Digest, writeReport)
-		if err != nil {
-			return nil, err
-		}
+		defer timer.phase("seed")()
+		return daemon.startSeedingLayer(ctx, ociImg, layer.Digest, writeReport)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer timer.phase("store")()
+	if err = daemon.putImage(ctx, ociImg, img, writeReport); err != nil {
+		return nil, err
+	}
+	if daemon.getConfig().BtImageTorrent {
+		daemon.startSeedingImage(ctx, ociImg, srcRef, img, writeReport)
+	}
+
+	sigs, err := img.Signatures()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading signatures: %v", err)
+	}
+	writeReport("Storing signatures\n")
+	if err = ociImg.layout.PutSignatures(ctx, ociImg.ref, sigs); err != nil {
+		return nil, fmt.Errorf("Error writing signatures: %v", err)
 	}
 
 	return &types.StartDownloadResponse{}, nil
@@ -173,16 +340,32 @@ func (daemon *Daemon) copyLayer(ctx context.Context, ociImg *OciImage, src image
 	}
 	defer srcStream.Close()
 
-	if reportWriter != nil {
+	// A stalled read from the registry is interrupted once ctx is done
+	copied := make(chan struct{})
+	defer close(copied)
+	go func() {
+		select {
+		case <-ctx.Done():
+			srcStream.Close()
+		case <-copied:
+		}
+	}()
+
+	if reporting(reportWriter) {
 		bar := utils.NewProgressBar(int(srcInfo.Size), reportWriter)
 		bar.Start()
 
 		srcStream = bar.NewProxyReader(srcStream)
 		defer fmt.Fprint(reportWriter, "\n")
+		// Stops the refresh of the bar
+		defer bar.Finish()
 	}
 
 	digest, _, err := ociImg.layout.PutBlob(ctx, srcStream)
 	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return fmt.Errorf("Error writing blob: %v", err)
 	}
 
@@ -196,32 +379,26 @@ func (daemon *Daemon) startSeedingLayer(ctx context.Context, ociImg *OciImage, d
 	id := distdigests.Digest(digest).Hex()
 	// Write layer to file
 	fn := daemon.btEngine.GetFilePath(id)
-	if daemon.config.UseHardlink {
+	if daemon.getConfig().BlobStorage {
+		// Seed the blob itself
 		src, err := ociImg.layout.GetBlobPath(ctx, digest)
 		if err != nil {
 			return fmt.Errorf("Get oci blob path error: %v", err)
 		}
-		if err = os.Link(src, fn); err != nil {
-			return fmt.Errorf("Create hardlink error: %v", err)
+		if err = daemon.btEngine.SetBlobPath(id, src, ""); err != nil {
+			log.Warnf("Seed layer %s from its blob failed: %v", id, err)
 		}
 	} else {
-		layerFile, err := os.Create(fn)
-		if err != nil {
-			return fmt.Errorf("Create layer file %s failed: %v", fn, err)
-		}
-		defer layerFile.Close()
-
 		// Copy from OCI directory
-		srcFile, err := ociImg.layout.GetBlob(ctx, digest)
+		src, err := ociImg.layout.GetBlobPath(ctx, digest)
 		if err != nil {
-			return fmt.Errorf("Open oci layer %s error: %v", digest, err)
+			return fmt.Errorf("Get oci blob path error: %v", err)
 		}
-		defer srcFile.Close()
-
-		_, err = io.Copy(layerFile, srcFile)
+		used, err := utils.CopyFile(src, fn, daemon.getConfig().CopyMode())
 		if err != nil {
 			return fmt.Errorf("Copy oci layer %s error: %v", digest, err)
 		}
+		reportCopy(id, used, writeReport)
 	}
 
 	writeReport("Start seeding %s\n", id)
@@ -236,10 +413,10 @@ func (daemon *Daemon) startSeedingLayer(ctx context.Context, ociImg *OciImage, d
 	return nil
 }
 
-func (daemon *Daemon) startLeecherDownload(ctx context.Context, source string, reportWriter io.Writer, writeReport func(f string, a ...interface{})) (*types.StartDownloadResponse, error) {
+func (daemon *Daemon) startLeecherDownload(ctx context.Context, r *types.StartDownloadRequest, timer *pullTimer, reportWriter io.Writer, writeReport func(f string, a ...interface{})) (*types.StartDownloadResponse, error) {
 	sysCtx := daemon.getSystemContext(ctx)
 
-	imageSource := source
+	imageSource := r.Source
 	if imageSource == "" {
 		return nil, fmt.Errorf("Image source cannot be empty")
 	}
@@ -250,10 +427,24 @@ func (daemon *Daemon) startLeecherDownload(ctx context.Context, source string, r
 	}
 
 	writeReport("Get layer info %s\n", imageSource)
+	stop := timer.phase("manifest")
 	img, err := srcRef.NewImage(sysCtx)
 	if err != nil {
 		return nil, fmt.Errorf("Error new image %v", err)
 	}
+	defer img.Close()
+
+	writeReport("Get signatures from seeder\n")
+	sigs, err := daemon.getSignaturesFromSeeder(srcRef)
+	if err != nil {
+		return nil, fmt.Errorf("Get signatures from seeder failed: %v", err)
+	}
+	err = daemon.checkImagePolicy(&seederSignedImage{Image: img, signatures: sigs})
+	stop()
+	if err != nil {
+		return nil, err
+	}
+
 	layerInfos := img.LayerInfos()
 	log.Debugf("layerInfos: %v", layerInfos)
 
@@ -263,10 +454,136 @@ func (daemon *Daemon) startLeecherDownload(ctx context.Context, source string, r
 	}
 	defer ociImg.Close()
 
+	pull := daemon.newPull(layerInfos, r)
 	writeReport("Start download image: %s\n", imageSource)
-	for _, layer := range layerInfos {
-		var ok bool
-		if ok, err = ociImg.layout.Exist(ctx, layer.Digest); err != nil {
+	if daemon.getConfig().BtImageTorrent {
+		// The layers missing after a failure are leeched one by one. The
+		// image torrent comes before all the layers of the pull.
+		release, err := pull.acquire(ctx, torrentSlot, "")
+		if err != nil {
+			return nil, err
+		}
+		err = daemon.leechImage(ctx, ociImg, srcRef, img, timer, writeReport, reportWriter)
+		release()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			log.Warnf("Leech image %s failed, leech its layers: %v", imageSource, err)
+		}
+	}
+	missing, err := daemon.missingLayers(ctx, ociImg, layerInfos, writeReport)
+	if err != nil {
+		return nil, err
+	}
+
+	bars, writeReport := layerProgress(missing, reportWriter, writeReport)
+	err = eachLayer(missing, func(layer imagetypes.BlobInfo) error {
+		daemon.trackImageLayer(namedKey(srcRef.DockerReference()), distdigests.Digest(layer.Digest).Hex())
+		return daemon.pullLayer(ctx, srcRef, ociImg, layer, bars(layer.Digest), writeReport, func(ctx context.Context, ociImg *OciImage, w io.Writer) error {
+			writeReport := func(f string, a ...interface{}) {
+				fmt.Fprintf(w, f, a...)
+			}
+			release, err := pull.acquire(ctx, torrentSlot, layer.Digest)
+			if err != nil {
+				return err
+			}
+			err = daemon.startLeechingLayer(ctx, ociImg, srcRef, layer, timer, writeReport, w)
+			release()
+			if err == nil {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if !daemon.getConfig().RegistryFallback {
+				return err
+			}
+
+			// Fall back to the registry, the layer digest is still verified
+			log.Warnf("Leech layer %s failed, pull it from registry: %v", layer.Digest, err)
+			registryFallbacks.Inc(imageSource)
+			release, err = pull.acquire(ctx, registrySlot, layer.Digest)
+			if err != nil {
+				return err
+			}
+			defer release()
+			source, err := srcRef.NewImageSource(daemon.getSystemContext(ctx), ociSupportedManifestMIMETypes())
+			if err != nil {
+				return fmt.Errorf("Error initializing source %s: %v", transports.ImageName(srcRef), err)
+			}
+			defer source.Close()
+			writeReport("Copying layer %s from registry\n", layer.Digest)
+			stop := timer.phase("registry")
+			err = daemon.copyLayer(ctx, ociImg, source, layer, w)
+			stop()
+			if err != nil {
+				log.Errorf("Error copy layer %s: %v", layer.Digest, err)
+				return err
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer timer.phase("store")()
+	if err = daemon.putImage(ctx, ociImg, img, writeReport); err != nil {
+		return nil, err
+	}
+
+	writeReport("Storing signatures\n")
+	if err = ociImg.layout.PutSignatures(ctx, ociImg.ref, sigs); err != nil {
+		return nil, fmt.Errorf("Error writing signatures: %v", err)
+	}
+
+	return &types.StartDownloadResponse{}, nil
+}
+
+// pullLayer stores layer into the OCI directory of ociImg, the directory
+// of ref, with fetch. A pull of the layer in progress for another image is
+// joined instead, and the layer it stored is copied. fetch may outlive the
+// pull starting it: it gets its own handle of the OCI directory and writes
+// the progress to w of all the pulls waiting for the layer. It is cancelled
+// once none waits.
+func (daemon *Daemon) pullLayer(ctx context.Context, ref imagetypes.ImageReference, ociImg *OciImage, layer imagetypes.BlobInfo, w io.Writer, writeReport func(f string, a ...interface{}), fetch func(context.Context, *OciImage, io.Writer) error) error {
+	fn, err, shared := daemon.layerPulls.do(ctx, layer.Digest, w, func(ctx context.Context, w io.Writer) (string, error) {
+		flightImg, err := newOciImage(daemon, ref)
+		if err != nil {
+			return "", err
+		}
+		defer flightImg.Close()
+		if err := fetch(ctx, flightImg, w); err != nil {
+			return "", err
+		}
+		return flightImg.layout.GetBlobPath(ctx, layer.Digest)
+	}, func() {
+		log.Infof("Join the pull of layer %s in progress", layer.Digest)
+		writeReport("%s: Waiting for the pull in progress\n", layer.Digest)
+		pullsJoined.Inc("layer")
+	})
+	if err != nil || !shared {
+		return err
+	}
+
+	ok, err := ociImg.layout.Exist(ctx, layer.Digest)
+	if err != nil {
+		return fmt.Errorf("Error check OCI dest blob exist: %v", err)
+	}
+	if ok {
+		return nil
+	}
+	return daemon.storeLayerFile(ctx, ociImg, fn, layer.Digest, writeReport)
+}
+
+// missingLayers returns the layers not stored in the OCI directory yet,
+// without duplicates
+func (daemon *Daemon) missingLayers(ctx context.Context, ociImg *OciImage, layers []imagetypes.BlobInfo, writeReport func(f string, a ...interface{})) ([]imagetypes.BlobInfo, error) {
+	var missing []imagetypes.BlobInfo
+	for _, layer := range uniqueLayers(layers) {
+		ok, err := ociImg.layout.Exist(ctx, layer.Digest)
+		if err != nil {
 			return nil, fmt.Errorf("Error check OCI dest blob exist: %v", err)
 		}
 
@@ -276,103 +593,185 @@ func (daemon *Daemon) startLeecherDownload(ctx context.Context, source string, r
 			log.Infof("Layer %s exist, skip it", layer.Digest)
 			continue
 		}
-
-		err = daemon.startLeechingLayer(ctx, ociImg, srcRef, layer, writeReport, reportWriter)
-		if err != nil {
-			// FIXME: download from image source
-			return nil, err
-		}
+		missing = append(missing, layer)
 	}
+	return missing, nil
+}
 
+// putImage writes the image config and manifest to the OCI directory
+func (daemon *Daemon) putImage(ctx context.Context, ociImg *OciImage, img imagetypes.Image, writeReport func(f string, a ...interface{})) error {
 	// Pull image config
 	srcInfo := img.ConfigInfo()
 	if srcInfo.Digest != "" {
 		writeReport("Copying config %s\n", srcInfo.Digest)
 		configBlob, err := img.ConfigBlob()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		digest, _, err := ociImg.layout.PutBlob(ctx, bytes.NewReader(configBlob))
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		if digest != srcInfo.Digest {
-			return nil, fmt.Errorf("Error config blob %s changed to %s", srcInfo.Digest, digest)
+			return fmt.Errorf("Error config blob %s changed to %s", srcInfo.Digest, digest)
 		}
 	} else {
-		log.Infof("Config of %s is empty", imageSource)
+		log.Infof("Config of %s is empty", transports.ImageName(img.Reference()))
 	}
 
 	// Pull manifest
 	manifest, _, err := img.Manifest()
 	if err != nil {
-		return nil, fmt.Errorf("Error reading manifest: %v", err)
+		return fmt.Errorf("Error reading manifest: %v", err)
 	}
 
 	writeReport("Writing manifest to image destination\n")
 	if err = daemon.putManifest(ctx, ociImg, manifest); err != nil {
-		return nil, fmt.Errorf("Error writing manifest: %v", err)
+		return fmt.Errorf("Error writing manifest: %v", err)
 	}
-
-	return &types.StartDownloadResponse{}, nil
+	return nil
 }
 
-func (daemon *Daemon) startLeechingLayer(ctx context.Context, ociImg *OciImage, ref imagetypes.ImageReference, layer imagetypes.BlobInfo, writeReport func(f string, a ...interface{}), reportWriter io.Writer) error {
+func (daemon *Daemon) startLeechingLayer(ctx context.Context, ociImg *OciImage, ref imagetypes.ImageReference, layer imagetypes.BlobInfo, timer *pullTimer, writeReport func(f string, a ...interface{}), reportWriter io.Writer) error {
 	id := distdigests.Digest(layer.Digest).Hex()
 
 	log.Debugf("Start leeching layer %s", id)
-	writeReport("%s: Get torrent data from seeder\n", id)
-	t, err := daemon.getTorrentFromSeeder(id)
+	validate := func(t []byte) error {
+		return bt.ValidateLayerTorrent(t, layer.Digest, layer.Size)
+	}
+
+	// In magnet mode only the infohash comes from the seeder, the torrent
+	// info is fetched from the peers and validated before downloading
+	var (
+		t      []byte
+		magnet string
+		peers  []string
+		err    error
+	)
+	useMagnet := daemon.getConfig().BtMagnet
+	stop := timer.phase("torrent")
+	if useMagnet {
+		writeReport("%s: Get magnet link from seeder\n", id)
+		magnet, peers, err = daemon.getMagnetFromSeeder(id)
+	} else {
+		writeReport("%s: Get torrent data from seeder\n", id)
+		t, peers, err = daemon.getTorrentFromSeeder(id)
+	}
+	stop()
 	if err != nil {
 		log.Errorf("Get torrent data from seeder for %s failed: %v", id, err)
 		return err
 	}
 
+	if !useMagnet {
+		if err = validate(t); err != nil {
+			log.Errorf("Invalid torrent data for %s from seeder: %v", id, err)
+			return err
+		}
+	}
+
+	// With blob storage, the layer is downloaded into the OCI directory
+	// unless its torrent is already started
+	useBlob := daemon.getConfig().BlobStorage
+	if useBlob {
+		if err = daemon.setLayerBlob(ctx, ociImg, id, layer.Digest); err != nil {
+			log.Debugf("Download layer %s to the data directory: %v", id, err)
+			useBlob = false
+		}
+	}
+
 	var progress *bt.ProgressDownload
-	if reportWriter != nil {
+	if reporting(reportWriter) {
 		progress = bt.NewProgressDownload(id, int(layer.Size), reportWriter)
 	}
 	// Download layer file
-	if err := daemon.btEngine.StartLeecher(id, t, progress); err != nil {
+	stop = timer.phase("download")
+	if useMagnet {
+		err = daemon.btEngine.StartMagnetLeecher(ctx, id, magnet, peers, validate, progress)
+	} else {
+		err = daemon.btEngine.StartLeecher(ctx, id, t, peers, progress)
+	}
+	stop()
+	if err != nil {
 		log.Errorf("Download layer %s failed: %v", id, err)
 		return err
 	} else {
 		log.Infof("Download layer %s success", id)
 	}
 
+	fn := daemon.btEngine.GetFilePath(id)
+	writeReport("%s: Verify layer digest\n", id)
+	stop = timer.phase("verify")
+	if useBlob {
+		// The blob is only renamed into place once verified
+		err = daemon.btEngine.CommitBlob(id)
+	} else {
+		err = bt.VerifyLayerFile(fn, layer.Digest)
+	}
+	stop()
+	if err != nil {
+		log.Errorf("Verify layer %s failed: %v", id, err)
+		daemon.dropTorrent(id)
+		return err
+	}
+	if useBlob {
+		return nil
+	}
+
 	// Copy to OCI directory
 	writeReport("%s: Copy to OCI directory\n", id)
+	defer timer.phase("copy")()
+	return daemon.storeLayerFile(ctx, ociImg, fn, layer.Digest, writeReport)
+}
 
-	fn := daemon.btEngine.GetFilePath(id)
-	if daemon.config.UseHardlink {
-		dst, err := ociImg.layout.GetBlobPath(ctx, layer.Digest)
-		if err != nil {
-			return fmt.Errorf("Get oci blob path error: %v", err)
-		}
-		if err = os.Link(fn, dst); err != nil {
-			return fmt.Errorf("Create hardlink error: %v", err)
-		}
-	} else {
-		layerFile, err := os.Open(fn)
-		if err != nil {
-			return fmt.Errorf("Open layer file %s failed: %v", fn, err)
-		}
-		defer layerFile.Close()
+// setLayerBlob downloads layer id into its blob in the OCI directory
+func (daemon *Daemon) setLayerBlob(ctx context.Context, ociImg *OciImage, id, digest string) error {
+	dst, err := ociImg.layout.GetBlobPath(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("Get oci blob path error: %v", err)
+	}
+	tmp, err := ociImg.layout.GetBlobTempPath(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("Get oci blob temp path error: %v", err)
+	}
+	return daemon.btEngine.SetBlobPath(id, dst, tmp)
+}
 
-		digest, _, err := ociImg.layout.PutBlob(ctx, layerFile)
-		if err != nil {
-			return fmt.Errorf("Error to put blob %s: %v", layer.Digest, err)
-		}
+// dropTorrent stops and deletes the torrent of id, so that corrupted data
+// is never seeded or stored
+func (daemon *Daemon) dropTorrent(id string) {
+	if err := daemon.btEngine.StopTorrent(id); err != nil {
+		log.Errorf("Stop torrent %s failed: %v", id, err)
+	}
+	if err := daemon.btEngine.DeleteTorrent(id); err != nil {
+		log.Errorf("Delete torrent %s error: %v", id, err)
+	}
+}
 
-		if digest != layer.Digest {
-			return fmt.Errorf("Digest not match, src: %s, dest: %s", layer.Digest, digest)
-		}
+// storeLayerFile puts the downloaded layer file fn with digest, already
+// verified, into the OCI directory
+func (daemon *Daemon) storeLayerFile(ctx context.Context, ociImg *OciImage, fn, digest string, writeReport func(f string, a ...interface{})) error {
+	dst, err := ociImg.layout.GetBlobPath(ctx, digest)
+	if err != nil {
+		return fmt.Errorf("Get oci blob path error: %v", err)
 	}
+	used, err := utils.CopyFile(fn, dst, daemon.getConfig().CopyMode())
+	if err != nil {
+		return fmt.Errorf("Error to put blob %s: %v", digest, err)
+	}
+	reportCopy(digest, used, writeReport)
 	return nil
 }
 
+// reportCopy reports the strategy used to copy layer id
+func reportCopy(id string, used utils.CopyMode, writeReport func(f string, a ...interface{})) {
+	log.Infof("Copied layer %s with %s", id, used)
+	writeReport("%s: Copied with %s\n", id, used)
+	layerCopies.Inc(string(used))
+}
+
 func (daemon *Daemon) StopDownload(ctx context.Context, r *types.StopDownloadRequest) (*types.StopDownloadResponse, error) {
 	imageSource := r.Source
 	if imageSource == "" {
@@ -396,9 +795,16 @@ func (daemon *Daemon) StopDownload(ctx context.Context, r *types.StopDownloadReq
 		return nil, err
 	}
 
-	var ids []string
+	var torrentIds []string
 	for _, layer := range layers {
-		id := distdigests.Digest(layer.digest).Hex()
+		torrentIds = append(torrentIds, distdigests.Digest(layer.digest).Hex())
+	}
+	if id := daemon.imageTorrent(namedKey(ref)); id != "" {
+		torrentIds = append(torrentIds, id)
+	}
+
+	var ids []string
+	for _, id := range torrentIds {
 		// Stop download layer file
 		if err = daemon.btEngine.StopTorrent(id); err != nil {
 			// FIXME: return failed layer info to client
@@ -475,9 +881,42 @@ func (daemon *Daemon) Status(ctx context.Context, r *types.StatusRequest) (*type
 		}
 		lss = append(lss, ls)
 	}
-	return &types.StatusResponse{
+	resp := &types.StatusResponse{
 		LayerDownStates: lss,
-	}, nil
+		BandwidthWindow: daemon.getBandwidthWindow(),
+	}
+	if upload, err := daemon.btEngine.GetUploadRateLimit(); err == nil {
+		resp.UploadRateLimit = int64(upload)
+	}
+	if download, err := daemon.btEngine.GetDownloadRateLimit(); err == nil {
+		resp.DownloadRateLimit = int64(download)
+	}
+	return resp, nil
+}
+
+func (daemon *Daemon) GetDaemonInfo(ctx context.Context, r *types.GetDaemonInfoRequest) (*types.GetDaemonInfoResponse, error) {
+	c := daemon.getConfig()
+	resp := &types.GetDaemonInfoResponse{
+		BtEnabled:  c.BtEnable,
+		Seeder:     c.BtSeeder,
+		Tcp:        !c.BtDisableTCP,
+		Utp:        !c.BtDisableUTP,
+		Encryption: c.Encryption(),
+		Dht:        c.BtDHT,
+		Lsd:        c.BtLSD,
+		Private:    c.BtPrivate,
+		Zone:       c.Zone,
+		Rack:       c.Rack,
+		Trackers:   c.BtTrackers,
+	}
+	if daemon.btEngine.Started() {
+		info := daemon.btEngine.TransportInfo()
+		resp.ListenAddr = info.ListenAddr
+		resp.Tcp = info.TCP
+		resp.Utp = info.UTP
+		resp.Encryption = info.Encryption
+	}
+	return resp, nil
 }
 
 func (daemon *Daemon) GetTorrent(ctx context.Context, r *types.GetTorrentRequest) (*types.GetTorrentResponse, error) {
@@ -485,36 +924,125 @@ func (daemon *Daemon) GetTorrent(ctx context.Context, r *types.GetTorrentRequest
 	if err != nil {
 		return nil, err
 	}
+	peers, err := daemon.btEngine.GetPeers(r.Id, maxHandoffPeers)
+	if err != nil {
+		log.Debugf("Get peers of %s failed: %v", r.Id, err)
+	}
 	return &types.GetTorrentResponse{
-		Torrent: t,
+		Torrent:    t,
+		ListenAddr: daemon.btEngine.ListenAddr(),
+		Peers:      peers,
 	}, nil
 }
 
-func (daemon *Daemon) getTorrentFromSeeder(id string) ([]byte, error) {
-	if len(daemon.config.BtSeederServer) < 1 {
-		return nil, fmt.Errorf("Seeder server cannot be empty")
+// getTorrentFromSeeder returns the torrent of id and the peers known to
+// the seeder, starting with the seeder itself
+func (daemon *Daemon) getTorrentFromSeeder(id string) ([]byte, []string, error) {
+	seeders := daemon.getConfig().BtSeederServer
+	if len(seeders) < 1 {
+		return nil, nil, fmt.Errorf("Seeder server cannot be empty")
 	}
 
 	// FIXME: round-robin seeder
-	cli := daemon.getRemotePeer(daemon.config.BtSeederServer[0])
+	cli, err := daemon.getRemotePeer(seeders[0])
+	if err != nil {
+		return nil, nil, err
+	}
 	r := &types.GetTorrentRequest{
 		Id: id,
 	}
 	resp, err := cli.GetTorrent(context.Background(), r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var peers []string
+	if addr := seederPeerAddr(seeders[0], resp.ListenAddr); addr != "" {
+		peers = append(peers, addr)
+	}
+	return resp.Torrent, append(peers, resp.Peers...), nil
+}
+
+// seederPeerAddr returns the bittorrent address of the seeder whose GRPC
+// address is seeder, an unspecified listen host is replaced by the host
+// of seeder
+func seederPeerAddr(seeder, listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return ""
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		return listenAddr
+	}
+
+	parts := strings.SplitN(seeder, "://", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	if parts[0] != "tcp" {
+		// Local seeder
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	seederHost, _, err := net.SplitHostPort(parts[1])
+	if err != nil {
+		return ""
+	}
+	ips, err := net.LookupIP(seederHost)
+	if err != nil || len(ips) == 0 {
+		return ""
+	}
+	return net.JoinHostPort(ips[0].String(), port)
+}
+
+func (daemon *Daemon) GetSignatures(ctx context.Context, r *types.GetSignaturesRequest) (*types.GetSignaturesResponse, error) {
+	imageSource := r.Source
+	if imageSource == "" {
+		return nil, fmt.Errorf("Image source cannot be empty")
+	}
+
+	ref, err := daemon.buildNamedTagged(imageSource)
 	if err != nil {
 		return nil, err
 	}
 
-	return resp.Torrent, nil
+	ociImg, err := newOciImageSimple(daemon, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer ociImg.Close()
+
+	sigs, err := ociImg.layout.GetSignatures(ctx, ociImg.ref)
+	if err != nil {
+		return nil, err
+	}
+	return &types.GetSignaturesResponse{
+		Signatures: sigs,
+	}, nil
 }
 
-// getPolicyContext handles the global "policy" flag.
-func (daemon *Daemon) getPolicyContext() (*signature.PolicyContext, error) {
-	policy, err := signature.DefaultPolicy(nil)
+func (daemon *Daemon) getSignaturesFromSeeder(ref imagetypes.ImageReference) ([][]byte, error) {
+	seeders := daemon.getConfig().BtSeederServer
+	if len(seeders) < 1 {
+		return nil, fmt.Errorf("Seeder server cannot be empty")
+	}
+	if ref.DockerReference() == nil {
+		return nil, fmt.Errorf("Image %s has no docker reference", transports.ImageName(ref))
+	}
+
+	// FIXME: round-robin seeder
+	cli, err := daemon.getRemotePeer(seeders[0])
 	if err != nil {
 		return nil, err
 	}
-	return signature.NewPolicyContext(policy)
+	r := &types.GetSignaturesRequest{
+		Source: ref.DockerReference().String(),
+	}
+	resp, err := cli.GetSignatures(context.Background(), r)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Signatures, nil
 }
 
 func (daemon *Daemon) getSystemContext(ctx context.Context) (sysCtx *imagetypes.SystemContext) {
@@ -524,11 +1052,11 @@ func (daemon *Daemon) getSystemContext(ctx context.Context) (sysCtx *imagetypes.
 		ok       bool
 	)
 
-	// FIXME: get value from daemon.config
+	config := daemon.getConfig()
 	sysCtx = &imagetypes.SystemContext{
-		RegistriesDirPath:           "",
-		DockerCertPath:              "",
-		DockerInsecureSkipTLSVerify: true,
+		RegistriesDirPath:           config.RegistriesDirPath,
+		DockerCertPath:              config.RegistryCertPath,
+		DockerInsecureSkipTLSVerify: config.RegistryInsecureSkipVerify,
 	}
 
 	if username, ok = ctx.Value(usernameKey).(string); !ok {
@@ -557,5 +1085,5 @@ func (daemon *Daemon) buildNamedTagged(source string) (reference.Named, error) {
 }
 
 func (daemon *Daemon) btRootDir() string {
-	return path.Join(daemon.config.Root, "bt")
+	return path.Join(daemon.getConfig().Root, "bt")
 }
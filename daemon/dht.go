@@ -0,0 +1,89 @@
+package daemon
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/hustcat/oci-torrent/api/grpc/types"
+)
+
+// dhtResolveInterval is how often the seeders which did not give their
+// bittorrent address are asked again
+const dhtResolveInterval = time.Minute
+
+// resolveDHTNodes bootstraps the private DHT from the TCP seeders, at the
+// address they listen on for bittorrent, until all of them answered or the
+// seeders or bt-dht-node changed
+func (daemon *Daemon) resolveDHTNodes(seeders []string) {
+	for {
+		nodes, missing := daemon.seederDHTNodes(seeders)
+
+		c := daemon.getConfig()
+		if len(c.BtDHTNodes) != 0 || !reflect.DeepEqual(c.BtSeederServer, seeders) {
+			return
+		}
+		if len(nodes) != 0 {
+			daemon.btEngine.SetDHTNodes(nodes)
+			log.Infof("DHT nodes resolved from seeders: %v", nodes)
+		}
+		if !missing {
+			return
+		}
+		time.Sleep(dhtResolveInterval)
+	}
+}
+
+// seederDHTNodes returns the host of each TCP seeder joined with the port
+// of the bittorrent listen address it reports, and whether a seeder did
+// not report it
+func (daemon *Daemon) seederDHTNodes(seeders []string) ([]string, bool) {
+	var (
+		nodes   []string
+		missing bool
+	)
+	for _, s := range seeders {
+		parts := strings.SplitN(s, "://", 2)
+		if len(parts) != 2 || parts[0] != "tcp" {
+			continue
+		}
+		host, _, err := net.SplitHostPort(parts[1])
+		if err != nil {
+			continue
+		}
+
+		port, err := daemon.seederBtPort(s)
+		if err != nil {
+			log.Warnf("Get bittorrent address of seeder %s failed: %v", s, err)
+			missing = true
+			continue
+		}
+		nodes = append(nodes, net.JoinHostPort(host, port))
+	}
+	return nodes, missing
+}
+
+// seederBtPort returns the port the seeder at address listens on for
+// bittorrent
+func (daemon *Daemon) seederBtPort(address string) (string, error) {
+	client, err := daemon.getRemotePeer(address)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), daemon.getConfig().ConnTimeout)
+	defer cancel()
+	info, err := client.GetDaemonInfo(ctx, &types.GetDaemonInfoRequest{})
+	if err != nil {
+		return "", err
+	}
+	_, port, err := net.SplitHostPort(info.ListenAddr)
+	if err != nil {
+		return "", fmt.Errorf("Bad listen address %q: %v", info.ListenAddr, err)
+	}
+	return port, nil
+}
@@ -0,0 +1,160 @@
+package daemon
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// flightGroup coalesces the concurrent calls with the same key: the calls
+// made while one is in flight wait for it and receive its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done   chan struct{}
+	result string
+	err    error
+
+	// Callers waiting for the call, it is cancelled when all of them gave
+	// up
+	waiters int
+	cancel  context.CancelFunc
+
+	out *flightWriter
+}
+
+// flightWriter writes the report of a call in flight to the writers of the
+// callers waiting for it
+type flightWriter struct {
+	mu      sync.Mutex
+	writers map[*io.Writer]bool
+}
+
+// add writes to w until the returned function is called
+func (fw *flightWriter) add(w io.Writer) func() {
+	if w == nil {
+		return func() {}
+	}
+	key := &w
+	fw.mu.Lock()
+	fw.writers[key] = true
+	fw.mu.Unlock()
+	return func() {
+		fw.mu.Lock()
+		delete(fw.writers, key)
+		fw.mu.Unlock()
+	}
+}
+
+// empty tells whether no caller reads the report
+func (fw *flightWriter) empty() bool {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return len(fw.writers) == 0
+}
+
+// reporting tells whether a client reads w, the report of a call in flight
+// may have no caller asking for it
+func reporting(w io.Writer) bool {
+	if fw, ok := w.(*flightWriter); ok {
+		return !fw.empty()
+	}
+	return w != nil
+}
+
+// Write never fails, the writers of the callers may be closed once they
+// went away
+func (fw *flightWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	for w := range fw.writers {
+		(*w).Write(p)
+	}
+	return len(p), nil
+}
+
+// flightContext is the context of a call in flight. It has the values of
+// the context of the caller which started it, but is only done once all
+// the callers are.
+type flightContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c flightContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: map[string]*flight{},
+	}
+}
+
+// do runs fn unless a call with key is in flight, in which case join is
+// called before waiting for it. It returns the result of the call and
+// whether it was shared with another caller, or the error of ctx once it
+// is done. The call goes on while other callers wait for it. The report
+// of the call is written to w until do returns.
+func (g *flightGroup) do(ctx context.Context, key string, w io.Writer, fn func(context.Context, io.Writer) (string, error), join func()) (string, error, bool) {
+	g.mu.Lock()
+	f, shared := g.calls[key]
+	if shared {
+		f.waiters++
+		g.mu.Unlock()
+		if join != nil {
+			join()
+		}
+		defer f.out.add(w)()
+	} else {
+		fctx, cancel := context.WithCancel(context.Background())
+		f = &flight{
+			done:    make(chan struct{}),
+			waiters: 1,
+			cancel:  cancel,
+			out:     &flightWriter{writers: map[*io.Writer]bool{}},
+		}
+		g.calls[key] = f
+		g.mu.Unlock()
+		defer f.out.add(w)()
+
+		go func() {
+			result, err := fn(flightContext{Context: fctx, values: ctx}, f.out)
+			g.mu.Lock()
+			f.result, f.err = result, err
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+			close(f.done)
+			g.mu.Unlock()
+			cancel()
+		}()
+	}
+
+	select {
+	case <-f.done:
+		return f.result, f.err, shared
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-f.done:
+		return f.result, f.err, shared
+	default:
+	}
+	f.waiters--
+	if f.waiters == 0 {
+		// The next caller starts a new call
+		if g.calls[key] == f {
+			delete(g.calls, key)
+		}
+		f.cancel()
+	}
+	return "", ctx.Err(), shared
+}
@@ -0,0 +1,141 @@
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFlightGroup(t *testing.T) {
+	g := newFlightGroup()
+	failed := errors.New("failed")
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	calls := 0
+
+	var wg sync.WaitGroup
+	results := make([]error, 3)
+	shared := make([]bool, 3)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, results[0], shared[0] = g.do(context.Background(), "layer", nil, func(context.Context, io.Writer) (string, error) {
+			calls++
+			close(started)
+			<-unblock
+			return "", failed
+		}, nil)
+	}()
+	<-started
+
+	joined := make(chan struct{}, 2)
+	for i := 1; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, results[i], shared[i] = g.do(context.Background(), "layer", nil, func(context.Context, io.Writer) (string, error) {
+				calls++
+				return "", nil
+			}, func() {
+				joined <- struct{}{}
+			})
+		}(i)
+	}
+	<-joined
+	<-joined
+	close(unblock)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+	for i, err := range results {
+		if err != failed {
+			t.Errorf("%d: expected the error of the call in flight, got %v", i, err)
+		}
+		if shared[i] != (i > 0) {
+			t.Errorf("%d: expected shared %v", i, i > 0)
+		}
+	}
+
+	// The next call runs again
+	result, err, ok := g.do(context.Background(), "layer", nil, func(context.Context, io.Writer) (string, error) {
+		return "done", nil
+	}, nil)
+	if result != "done" || err != nil || ok {
+		t.Errorf("expected a new call, got %q, %v, %v", result, err, ok)
+	}
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	g := newFlightGroup()
+	started := make(chan context.Context, 1)
+	unblock := make(chan struct{})
+	fn := func(ctx context.Context, w io.Writer) (string, error) {
+		started <- ctx
+		select {
+		case <-unblock:
+			fmt.Fprint(w, "done")
+			return "done", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	// The call goes on while a caller waits for it, and reports to the
+	// callers still waiting
+	var out1, out2 bytes.Buffer
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err, _ := g.do(ctx1, "image", &out1, fn, nil)
+		errs <- err
+	}()
+	fctx := <-started
+	joined := make(chan struct{})
+	results := make(chan string, 1)
+	go func() {
+		result, err, _ := g.do(context.Background(), "image", &out2, fn, func() {
+			close(joined)
+		})
+		results <- result
+		errs <- err
+	}()
+	<-joined
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected the cancelled caller to get %v, got %v", context.Canceled, err)
+	}
+	if fctx.Err() != nil {
+		t.Errorf("expected the call to go on, got %v", fctx.Err())
+	}
+	close(unblock)
+	if result, err := <-results, <-errs; result != "done" || err != nil {
+		t.Errorf("expected the result of the call, got %q, %v", result, err)
+	}
+	if out1.String() != "" || out2.String() != "done" {
+		t.Errorf("expected the report to the waiting caller only, got %q and %q", out1.String(), out2.String())
+	}
+
+	// The call is cancelled with its last caller
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	go func() {
+		_, err, _ := g.do(ctx2, "image", nil, func(ctx context.Context, w io.Writer) (string, error) {
+			started <- ctx
+			<-ctx.Done()
+			return "", ctx.Err()
+		}, nil)
+		errs <- err
+	}()
+	fctx = <-started
+	cancel2()
+	<-fctx.Done()
+	if err := <-errs; err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,190 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/Sirupsen/logrus"
+	distdigests "github.com/docker/distribution/digest"
+	"golang.org/x/net/context"
+
+	"github.com/containers/image/manifest"
+	imagetypes "github.com/containers/image/types"
+
+	"github.com/hustcat/oci-torrent/bt"
+)
+
+// imageBlobs returns the digest of the manifest of img and the blobs of its
+// image torrent: the manifest and the config with their content, then the
+// layers
+func imageBlobs(img imagetypes.Image) (string, []bt.ImageBlob, error) {
+	raw, _, err := img.Manifest()
+	if err != nil {
+		return "", nil, fmt.Errorf("Error reading manifest: %v", err)
+	}
+	digest, err := manifest.Digest(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	blobs := []bt.ImageBlob{{
+		Digest: digest,
+		Size:   int64(len(raw)),
+		Data:   raw,
+	}}
+
+	if info := img.ConfigInfo(); info.Digest != "" {
+		config, err := img.ConfigBlob()
+		if err != nil {
+			return "", nil, err
+		}
+		blobs = append(blobs, bt.ImageBlob{
+			Digest: info.Digest,
+			Size:   int64(len(config)),
+			Data:   config,
+		})
+	}
+
+	for _, layer := range img.LayerInfos() {
+		blobs = append(blobs, bt.ImageBlob{
+			Digest: layer.Digest,
+			Size:   layer.Size,
+		})
+	}
+	return digest, blobs, nil
+}
+
+// startSeedingImage seeds the image torrent of img, whose layers are stored
+// in the OCI directory
+func (daemon *Daemon) startSeedingImage(ctx context.Context, ociImg *OciImage, ref imagetypes.ImageReference, img imagetypes.Image, writeReport func(f string, a ...interface{})) {
+	digest, blobs, err := imageBlobs(img)
+	if err != nil {
+		log.Errorf("Seed image %s failed: %v", ociImg.ref, err)
+		return
+	}
+	for i := range blobs {
+		if blobs[i].Data != nil {
+			continue
+		}
+		if blobs[i].Path, err = ociImg.layout.GetBlobPath(ctx, blobs[i].Digest); err != nil {
+			log.Errorf("Get oci blob path error: %v", err)
+			return
+		}
+	}
+
+	id := distdigests.Digest(digest).Hex()
+	daemon.trackImageTorrent(namedKey(ref.DockerReference()), id)
+	writeReport("Start seeding image %s\n", id)
+	if err = daemon.btEngine.StartImageSeed(id, digest, blobs); err != nil {
+		log.Errorf("Seed image %s failed: %v", id, err)
+	} else {
+		log.Infof("Seed image %s success", id)
+	}
+}
+
+// leechImage downloads the layers of img missing in the OCI directory with
+// the image torrent straight into their blob
+func (daemon *Daemon) leechImage(ctx context.Context, ociImg *OciImage, ref imagetypes.ImageReference, img imagetypes.Image, timer *pullTimer, writeReport func(f string, a ...interface{}), reportWriter io.Writer) error {
+	digest, blobs, err := imageBlobs(img)
+	if err != nil {
+		return err
+	}
+
+	id := distdigests.Digest(digest).Hex()
+
+	// Layers shared with the images already stored are not downloaded
+	var (
+		missing []bt.ImageBlob
+		size    int64
+	)
+	for i, b := range blobs {
+		if b.Data != nil {
+			continue
+		}
+		ok, err := ociImg.layout.Exist(ctx, b.Digest)
+		if err != nil {
+			return fmt.Errorf("Error check OCI dest blob exist: %v", err)
+		}
+		if blobs[i].Path, err = ociImg.layout.GetBlobPath(ctx, b.Digest); err != nil {
+			return fmt.Errorf("Get oci blob path error: %v", err)
+		}
+		if ok {
+			continue
+		}
+		// Not the temporary file of a leech of the layer alone
+		temp, err := ociImg.layout.GetBlobTempPath(ctx, b.Digest)
+		if err != nil {
+			return fmt.Errorf("Get oci blob temp path error: %v", err)
+		}
+		blobs[i].Temp = temp + "-" + id
+		missing = append(missing, blobs[i])
+		size += b.Size
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	writeReport("%s: Get image torrent data from seeder\n", id)
+	stop := timer.phase("torrent")
+	t, peers, err := daemon.getTorrentFromSeeder(id)
+	stop()
+	if err != nil {
+		return fmt.Errorf("Get torrent data from seeder for image %s failed: %v", id, err)
+	}
+	if err = bt.ValidateImageTorrent(t, digest, blobs); err != nil {
+		return fmt.Errorf("Invalid torrent data for image %s from seeder: %v", id, err)
+	}
+
+	var progress *bt.ProgressDownload
+	if reporting(reportWriter) {
+		progress = bt.NewProgressDownload(id, int(size), reportWriter)
+	}
+	daemon.trackImageTorrent(namedKey(ref.DockerReference()), id)
+	stop = timer.phase("download")
+	err = daemon.btEngine.StartImageLeecher(ctx, id, t, peers, blobs, progress)
+	stop()
+	if err != nil {
+		return fmt.Errorf("Download image %s failed: %v", id, err)
+	}
+	log.Infof("Download image %s success", id)
+
+	for _, b := range missing {
+		writeReport("%s: Verify layer digest\n", b.Digest)
+		stop = timer.phase("verify")
+		fn, err := daemon.btEngine.CommitImageBlob(id, b.Digest)
+		stop()
+		if err != nil {
+			daemon.dropTorrent(id)
+			return fmt.Errorf("Verify layer %s failed: %v", b.Digest, err)
+		}
+		if fn == b.Path {
+			continue
+		}
+
+		// A leech of the image for another repository downloaded the blob
+		writeReport("%s: Copy to OCI directory\n", b.Digest)
+		stop = timer.phase("copy")
+		err = daemon.storeLayerFile(ctx, ociImg, fn, b.Digest, writeReport)
+		stop()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackImageTorrent records that id is the image torrent of the image named
+// key and applies the cap of the image to it
+func (daemon *Daemon) trackImageTorrent(key, id string) {
+	daemon.limitLock.Lock()
+	daemon.imageTorrents[key] = id
+	daemon.limitLock.Unlock()
+
+	daemon.trackImageLayer(key, id)
+}
+
+// imageTorrent returns the id of the image torrent of the image named key
+func (daemon *Daemon) imageTorrent(key string) string {
+	daemon.limitLock.Lock()
+	defer daemon.limitLock.Unlock()
+	return daemon.imageTorrents[key]
+}
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	distdigests "github.com/docker/distribution/digest"
+	"golang.org/x/net/context"
+
+	"github.com/hustcat/oci-torrent/api/grpc/types"
+)
+
+func (daemon *Daemon) GetMagnets(ctx context.Context, r *types.GetMagnetsRequest) (*types.GetMagnetsResponse, error) {
+	var ids []string
+	switch {
+	case r.Id != "":
+		ids = []string{r.Id}
+	case r.Source != "":
+		var err error
+		if ids, err = daemon.imageLayerIds(ctx, r.Source); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Image source or layer id is required")
+	}
+
+	resp := &types.GetMagnetsResponse{
+		ListenAddr: daemon.btEngine.ListenAddr(),
+	}
+	for _, id := range ids {
+		magnet, err := daemon.btEngine.Magnet(id)
+		if err != nil {
+			return nil, fmt.Errorf("Get magnet of layer %s failed: %v", id, err)
+		}
+		peers, err := daemon.btEngine.GetPeers(id, maxHandoffPeers)
+		if err != nil {
+			log.Debugf("Get peers of %s failed: %v", id, err)
+		}
+		resp.Magnets = append(resp.Magnets, &types.LayerMagnet{
+			Id:     id,
+			Magnet: magnet,
+			Peers:  peers,
+		})
+	}
+	return resp, nil
+}
+
+// imageLayerIds returns the ids of the layers of the image source stored
+// in the OCI directory
+func (daemon *Daemon) imageLayerIds(ctx context.Context, source string) ([]string, error) {
+	ref, err := daemon.buildNamedTagged(source)
+	if err != nil {
+		return nil, err
+	}
+	ociImg, err := newOciImageSimple(daemon, ref)
+	if err != nil {
+		return nil, fmt.Errorf("Image %s not found: %v", source, err)
+	}
+	defer ociImg.Close()
+
+	layers, err := daemon.getOciImageLayers(ctx, ociImg)
+	if err != nil {
+		return nil, fmt.Errorf("Get layers of image %s failed: %v", source, err)
+	}
+	var ids []string
+	for _, layer := range layers {
+		ids = append(ids, distdigests.Digest(layer.digest).Hex())
+	}
+	return ids, nil
+}
+
+// getMagnetFromSeeder returns the magnet URI of the torrent of id and the
+// peers known to the seeder, starting with the seeder itself
+func (daemon *Daemon) getMagnetFromSeeder(id string) (string, []string, error) {
+	seeders := daemon.getConfig().BtSeederServer
+	if len(seeders) < 1 {
+		return "", nil, fmt.Errorf("Seeder server cannot be empty")
+	}
+
+	cli, err := daemon.getRemotePeer(seeders[0])
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := cli.GetMagnets(context.Background(), &types.GetMagnetsRequest{Id: id})
+	if err != nil {
+		return "", nil, err
+	}
+	if len(resp.Magnets) != 1 || resp.Magnets[0].Id != id {
+		return "", nil, fmt.Errorf("Seeder returned no magnet for %s", id)
+	}
+
+	var peers []string
+	if addr := seederPeerAddr(seeders[0], resp.ListenAddr); addr != "" {
+		peers = append(peers, addr)
+	}
+	return resp.Magnets[0].Magnet, append(peers, resp.Magnets[0].Peers...), nil
+}
@@ -0,0 +1,198 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/hustcat/oci-torrent/bt"
+	"github.com/hustcat/oci-torrent/metrics"
+)
+
+var (
+	webSeedServedBytes = metrics.NewCounter("oci_torrent_webseed_served_bytes_total",
+		"Bytes of layers served to the leechers using the daemon as a web seed.")
+	pullDuration = metrics.NewHistogram("oci_torrent_pull_duration_seconds",
+		"Duration of image pulls by phase.", metrics.DefBuckets, "image", "phase")
+	pullsTotal = metrics.NewCounter("oci_torrent_pulls_total",
+		"Image pulls by result.", "result")
+	pullsJoined = metrics.NewCounter("oci_torrent_pulls_joined_total",
+		"Pulls of images and layers joining the pull in progress.", "kind")
+	layerCopies = metrics.NewCounter("oci_torrent_layer_copies_total",
+		"Layers copied between the OCI store and the bt engine by strategy.", "strategy")
+	registryFallbacks = metrics.NewCounter("oci_torrent_registry_fallback_total",
+		"Layers pulled from the registry after bittorrent failed.", "image")
+	ociStoreBytes = metrics.NewGauge("oci_torrent_oci_store_bytes",
+		"Disk usage of the OCI image store.")
+	dhtNodes = metrics.NewGauge("oci_torrent_dht_nodes",
+		"Nodes known to the private DHT.")
+)
+
+// The totals of the engine, read on each collection
+func init() {
+	engineCounter := func(name, help string, value func(c bt.Counters) int64) {
+		metrics.NewCounterFunc(name, help, func(emit func(float64, ...string)) {
+			emit(float64(value(bt.GetCounters())))
+		})
+	}
+	engineCounter("oci_torrent_pieces_verified_total",
+		"Pieces whose hash was verified.",
+		func(c bt.Counters) int64 { return c.PiecesVerified })
+	engineCounter("oci_torrent_pieces_failed_total",
+		"Pieces whose hash did not match.",
+		func(c bt.Counters) int64 { return c.PiecesFailed })
+	engineCounter("oci_torrent_tracker_announce_errors_total",
+		"Failed announces to trackers.",
+		func(c bt.Counters) int64 { return c.TrackerAnnounceErrors })
+	engineCounter("oci_torrent_peers_unauthenticated_total",
+		"Peers refused because they failed to prove the peer secret.",
+		func(c bt.Counters) int64 { return c.PeersUnauthenticated })
+	engineCounter("oci_torrent_webseed_bytes_total",
+		"Bytes of pieces the swarm could not supply, fetched from web seeds.",
+		func(c bt.Counters) int64 { return c.WebSeedBytes })
+	engineCounter("oci_torrent_webseed_pieces_total",
+		"Pieces fetched from web seeds.",
+		func(c bt.Counters) int64 { return c.WebSeedPieces })
+	engineCounter("oci_torrent_webseed_errors_total",
+		"Failed piece fetches from web seeds.",
+		func(c bt.Counters) int64 { return c.WebSeedErrors })
+}
+
+// registerMetrics registers the metrics read from the torrents of the
+// daemon
+func (daemon *Daemon) registerMetrics() {
+	metrics.OnCollect(daemon.collectMetrics)
+
+	torrentMetric := func(register func(string, string, metrics.Collector, ...string), name, help string, value func(s bt.Status) float64) {
+		register(name, help, func(emit func(float64, ...string)) {
+			for _, s := range daemon.torrentStats {
+				emit(value(s), s.Id)
+			}
+		}, "id")
+	}
+	torrentMetric(metrics.NewGaugeFunc, "oci_torrent_torrent_downloaded_bytes",
+		"Bytes of the layer downloaded by the torrent.",
+		func(s bt.Status) float64 { return float64(s.Completed) })
+	torrentMetric(metrics.NewCounterFunc, "oci_torrent_torrent_uploaded_bytes_total",
+		"Bytes of the layer uploaded to peers by the torrent.",
+		func(s bt.Status) float64 { return float64(s.Uploaded) })
+	torrentMetric(metrics.NewGaugeFunc, "oci_torrent_torrent_download_rate_bytes",
+		"Download rate of the torrent in bytes per second.",
+		func(s bt.Status) float64 { return float64(s.DownloadRate) })
+	metrics.NewGaugeFunc("oci_torrent_torrent_peers",
+		"Number of peers of the torrent, connected or known but not connected.",
+		func(emit func(float64, ...string)) {
+			for _, s := range daemon.torrentStats {
+				emit(float64(s.Peers), s.Id, "connected")
+				emit(float64(s.KnownPeers), s.Id, "known")
+			}
+		}, "id", "state")
+}
+
+// collectMetrics refreshes the engine state read by the metrics. The
+// collections are serialized, so torrentStats is only used by one at a
+// time.
+func (daemon *Daemon) collectMetrics() {
+	daemon.torrentStats = nil
+	if daemon.btEngine.Started() {
+		ss, err := daemon.btEngine.GetAllStatus()
+		if err != nil {
+			log.Errorf("Get torrent status for metrics failed: %v", err)
+		}
+		daemon.torrentStats = ss
+	}
+
+	dhtNodes.Set(float64(daemon.btEngine.DHTNodes()))
+}
+
+// diskUsageInterval is the interval of the computation of the disk usage
+// of the OCI store, which walks all its files
+const diskUsageInterval = 5 * time.Minute
+
+// runDiskUsage refreshes the disk usage of the OCI store
+func (daemon *Daemon) runDiskUsage() {
+	for {
+		ociStoreBytes.Set(float64(diskUsage(daemon.ociRootDir())))
+		time.Sleep(diskUsageInterval)
+	}
+}
+
+// diskUsage returns the size of the regular files under root
+func diskUsage(root string) int64 {
+	var size int64
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// pullTimer records the time spent in each phase of an image pull. A phase
+// run by the layers downloaded at once lasts from its first start to its
+// last end.
+type pullTimer struct {
+	image string
+	start time.Time
+
+	mu     sync.Mutex
+	phases map[string]*phaseSpan
+}
+
+// phaseSpan is the wall time of a phase
+type phaseSpan struct {
+	first, last time.Time
+}
+
+func newPullTimer(image string) *pullTimer {
+	return &pullTimer{
+		image:  image,
+		start:  time.Now(),
+		phases: map[string]*phaseSpan{},
+	}
+}
+
+// phase starts timing phase, the returned function stops it
+func (t *pullTimer) phase(name string) func() {
+	start := time.Now()
+	t.mu.Lock()
+	s, ok := t.phases[name]
+	if !ok {
+		s = &phaseSpan{first: start}
+		t.phases[name] = s
+	}
+	t.mu.Unlock()
+	return func() {
+		end := time.Now()
+		t.mu.Lock()
+		if end.After(s.last) {
+			s.last = end
+		}
+		t.mu.Unlock()
+	}
+}
+
+// done records the durations of the pull, failed pulls only count
+func (t *pullTimer) done(err error) {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		pullsTotal.Inc("cancelled")
+		return
+	}
+	if err != nil {
+		pullsTotal.Inc("failure")
+		return
+	}
+	pullsTotal.Inc("success")
+	for name, s := range t.phases {
+		if s.last.IsZero() {
+			continue
+		}
+		pullDuration.Observe(s.last.Sub(s.first).Seconds(), t.image, name)
+	}
+	pullDuration.Observe(time.Since(t.start).Seconds(), t.image, "total")
+}
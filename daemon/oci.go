@@ -67,7 +67,7 @@ func ociSupportedManifestMIMETypes() []string {
 }
 
 func (daemon *Daemon) ociRootDir() string {
-	return path.Join(daemon.config.Root, "oci")
+	return path.Join(daemon.getConfig().Root, "oci")
 }
 
 func (daemon *Daemon) buildOciDestFromReference(ref imagetypes.ImageReference) (string, string) {
@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/containers/image/signature"
+	"github.com/containers/image/transports"
+	imagetypes "github.com/containers/image/types"
+)
+
+// seederSignedImage is an image whose signatures were handed out by the
+// seeder instead of being read from the image source.
+type seederSignedImage struct {
+	imagetypes.Image
+	signatures [][]byte
+}
+
+func (i *seederSignedImage) Signatures() ([][]byte, error) {
+	return i.signatures, nil
+}
+
+// getPolicyContext handles the global "signature-policy" flag.
+func (daemon *Daemon) getPolicyContext() (*signature.PolicyContext, error) {
+	var (
+		policy *signature.Policy
+		err    error
+	)
+	if policyPath := daemon.getConfig().SignaturePolicy; policyPath != "" {
+		policy, err = signature.NewPolicyFromFile(policyPath)
+	} else {
+		policy, err = signature.DefaultPolicy(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return signature.NewPolicyContext(policy)
+}
+
+// checkImagePolicy returns an error unless the signature policy allows img.
+func (daemon *Daemon) checkImagePolicy(img imagetypes.UnparsedImage) error {
+	policyContext, err := daemon.getPolicyContext()
+	if err != nil {
+		return fmt.Errorf("Error loading signature policy: %v", err)
+	}
+	defer policyContext.Destroy()
+
+	allowed, err := policyContext.IsRunningImageAllowed(img)
+	if !allowed {
+		if err == nil {
+			err = fmt.Errorf("not allowed")
+		}
+		return fmt.Errorf("Image %s rejected by signature policy: %v", transports.ImageName(img.Reference()), err)
+	}
+	return nil
+}
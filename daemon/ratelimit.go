@@ -0,0 +1,131 @@
+package daemon
+
+import (
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	distdigests "github.com/docker/distribution/digest"
+	"golang.org/x/net/context"
+
+	"github.com/containers/image/docker/reference"
+
+	"github.com/hustcat/oci-torrent/api/grpc/types"
+	"github.com/hustcat/oci-torrent/bt"
+)
+
+func (daemon *Daemon) SetRateLimit(ctx context.Context, r *types.SetRateLimitRequest) (*types.SetRateLimitResponse, error) {
+	if r.Upload < 0 || r.Download < 0 {
+		return nil, fmt.Errorf("Rate limit cannot be negative")
+	}
+	limit := bt.RateLimit{
+		Upload:   int(r.Upload),
+		Download: int(r.Download),
+	}
+
+	switch {
+	case r.Id != "":
+		if err := daemon.btEngine.SetTorrentRateLimit(r.Id, limit); err != nil {
+			return nil, err
+		}
+		log.Infof("Rate limit of layer %s set to %+v", r.Id, limit)
+	case r.Source != "":
+		if err := daemon.setImageRateLimit(ctx, r.Source, limit); err != nil {
+			return nil, err
+		}
+		log.Infof("Rate limit of image %s set to %+v", r.Source, limit)
+	default:
+		if err := daemon.setGlobalRateLimit(limit); err != nil {
+			return nil, err
+		}
+		log.Infof("Global rate limit set to %+v", limit)
+	}
+	return &types.SetRateLimitResponse{}, nil
+}
+
+func (daemon *Daemon) GetRateLimit(ctx context.Context, r *types.GetRateLimitRequest) (*types.GetRateLimitResponse, error) {
+	var limit bt.RateLimit
+	switch {
+	case r.Id != "":
+		limit = daemon.btEngine.GetTorrentRateLimit(r.Id)
+	case r.Source != "":
+		key, err := daemon.imageKey(r.Source)
+		if err != nil {
+			return nil, err
+		}
+		limit = daemon.btEngine.GetGroupRateLimit(key)
+	default:
+		var err error
+		if limit.Upload, err = daemon.btEngine.GetUploadRateLimit(); err != nil {
+			return nil, err
+		}
+		if limit.Download, err = daemon.btEngine.GetDownloadRateLimit(); err != nil {
+			return nil, err
+		}
+	}
+	return &types.GetRateLimitResponse{
+		Upload:   int64(limit.Upload),
+		Download: int64(limit.Download),
+	}, nil
+}
+
+func (daemon *Daemon) setGlobalRateLimit(limit bt.RateLimit) error {
+	daemon.configLock.Lock()
+	defer daemon.configLock.Unlock()
+
+	if err := daemon.btEngine.SetUploadRateLimit(limit.Upload); err != nil {
+		return err
+	}
+	if err := daemon.btEngine.SetDownloadRateLimit(limit.Download); err != nil {
+		return err
+	}
+
+	config := *daemon.config
+	config.UploadRateLimit = limit.Upload
+	config.DownloadRateLimit = limit.Download
+	daemon.config = &config
+	return nil
+}
+
+// setImageRateLimit caps the summed rates of the layers of the image, those
+// already stored or being pulled and those pulled later. A layer shared
+// with other images is capped by each of them.
+func (daemon *Daemon) setImageRateLimit(ctx context.Context, source string, limit bt.RateLimit) error {
+	key, err := daemon.imageKey(source)
+	if err != nil {
+		return err
+	}
+
+	ref, err := daemon.buildNamedTagged(source)
+	if err != nil {
+		return err
+	}
+	if ociImg, err := newOciImageSimple(daemon, ref); err == nil {
+		layers, err := daemon.getOciImageLayers(ctx, ociImg)
+		ociImg.Close()
+		if err == nil {
+			for _, layer := range layers {
+				daemon.trackImageLayer(key, distdigests.Digest(layer.digest).Hex())
+			}
+		}
+	}
+
+	return daemon.btEngine.SetGroupRateLimit(key, limit)
+}
+
+// trackImageLayer records that the layer id belongs to the image named
+// key, so that it shares the cap of the image
+func (daemon *Daemon) trackImageLayer(key, id string) {
+	daemon.btEngine.AddGroupTorrent(key, id)
+}
+
+func (daemon *Daemon) imageKey(source string) (string, error) {
+	ref, err := daemon.buildNamedTagged(source)
+	if err != nil {
+		return "", err
+	}
+	return namedKey(ref), nil
+}
+
+func namedKey(named reference.Named) string {
+	return reference.WithDefaultTag(named).String()
+}
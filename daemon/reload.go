@@ -0,0 +1,148 @@
+package daemon
+
+import (
+	"reflect"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/hustcat/oci-torrent/bt"
+)
+
+func (daemon *Daemon) getConfig() *Config {
+	daemon.configLock.RLock()
+	defer daemon.configLock.RUnlock()
+	return daemon.config
+}
+
+// Reload applies the reloadable settings of config to the running daemon:
+// rate limits, cross zone rate limits, bandwidth schedule, trackers, seeder
+// addresses, allowed peers, log level, and the TLS files used to talk to
+// other daemons. Active torrents are kept. Changes to other settings are
+// logged and ignored until restart.
+func (daemon *Daemon) Reload(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	if daemon.reloadConfig(config) {
+		daemon.applyBandwidthSchedule(true)
+	}
+	// Rotated certificates may keep their paths
+	daemon.reloadRemoteTLS(daemon.getConfig())
+	return nil
+}
+
+// reloadConfig swaps the reloadable settings of the configuration and
+// returns true if the rate limits need to be applied again
+func (daemon *Daemon) reloadConfig(config *Config) bool {
+	daemon.configLock.Lock()
+	defer daemon.configLock.Unlock()
+
+	old := daemon.config
+	newConfig := *old
+
+	level, _ := log.ParseLevel(config.LogLevel)
+	if config.LogLevel != old.LogLevel {
+		log.SetLevel(level)
+		log.Infof("Log level changed to %s", level)
+	}
+	newConfig.LogLevel = config.LogLevel
+
+	limitsChanged := config.UploadRateLimit != old.UploadRateLimit ||
+		config.DownloadRateLimit != old.DownloadRateLimit ||
+		!reflect.DeepEqual(config.BandwidthSchedule, old.BandwidthSchedule)
+	if limitsChanged {
+		newConfig.UploadRateLimit = config.UploadRateLimit
+		newConfig.DownloadRateLimit = config.DownloadRateLimit
+		newConfig.BandwidthSchedule = config.BandwidthSchedule
+		log.Infof("Rate limits changed to upload %d, download %d, %d bandwidth windows",
+			config.UploadRateLimit, config.DownloadRateLimit, len(config.BandwidthSchedule))
+	}
+
+	if config.CrossZoneUploadRateLimit != old.CrossZoneUploadRateLimit ||
+		config.CrossZoneDownloadRateLimit != old.CrossZoneDownloadRateLimit {
+		limit := bt.RateLimit{
+			Upload:   config.CrossZoneUploadRateLimit,
+			Download: config.CrossZoneDownloadRateLimit,
+		}
+		if err := daemon.btEngine.SetCrossZoneRateLimit(limit); err != nil {
+			log.Errorf("Set cross zone rate limits failed: %v", err)
+		} else {
+			newConfig.CrossZoneUploadRateLimit = limit.Upload
+			newConfig.CrossZoneDownloadRateLimit = limit.Download
+			log.Infof("Cross zone rate limits changed to upload %d, download %d", limit.Upload, limit.Download)
+		}
+	}
+
+	if !reflect.DeepEqual(config.BtAllowedPeers, old.BtAllowedPeers) {
+		if err := daemon.btEngine.SetAllowedPeers(config.BtAllowedPeers); err != nil {
+			log.Errorf("Set allowed peers failed: %v", err)
+		} else {
+			newConfig.BtAllowedPeers = config.BtAllowedPeers
+			log.Infof("Allowed peers changed to %v", config.BtAllowedPeers)
+		}
+	}
+
+	if !reflect.DeepEqual(config.BtTrackers, old.BtTrackers) {
+		daemon.btEngine.SetTrackers(config.BtTrackers)
+		newConfig.BtTrackers = config.BtTrackers
+		log.Infof("Trackers changed to %v", config.BtTrackers)
+	}
+
+	if !reflect.DeepEqual(config.BtSeederServer, old.BtSeederServer) ||
+		!reflect.DeepEqual(config.BtDHTNodes, old.BtDHTNodes) {
+		newConfig.BtSeederServer = config.BtSeederServer
+		newConfig.BtDHTNodes = config.BtDHTNodes
+		daemon.closeRemotePeers(config.BtSeederServer)
+		log.Infof("Seeder addresses changed to %v, DHT nodes to %v", config.BtSeederServer, config.BtDHTNodes)
+		if len(config.BtDHTNodes) != 0 {
+			daemon.btEngine.SetDHTNodes(config.BtDHTNodes)
+		} else if newConfig.BtDHT {
+			go daemon.resolveDHTNodes(config.BtSeederServer)
+		}
+	}
+
+	if config.TLSCACert != old.TLSCACert || config.TLSCert != old.TLSCert || config.TLSKey != old.TLSKey {
+		newConfig.TLSCACert = config.TLSCACert
+		newConfig.TLSCert = config.TLSCert
+		newConfig.TLSKey = config.TLSKey
+		log.Warnf("The API listeners keep their TLS files until restart")
+	}
+
+	if config.MaxActiveTorrents != old.MaxActiveTorrents ||
+		config.MaxRegistryFetches != old.MaxRegistryFetches {
+		newConfig.MaxActiveTorrents = config.MaxActiveTorrents
+		newConfig.MaxRegistryFetches = config.MaxRegistryFetches
+		daemon.scheduler.setLimits(config.MaxActiveTorrents, config.MaxRegistryFetches)
+		log.Infof("Layer slots changed to %d torrents, %d registry fetches", config.MaxActiveTorrents, config.MaxRegistryFetches)
+	}
+	if config.LayerOrder != old.LayerOrder {
+		newConfig.LayerOrder = config.LayerOrder
+		log.Infof("Layer order changed to %s", config.LayerOrder)
+	}
+
+	// Everything else needs a restart
+	ignored := *config
+	ignored.LogLevel = newConfig.LogLevel
+	ignored.UploadRateLimit = newConfig.UploadRateLimit
+	ignored.DownloadRateLimit = newConfig.DownloadRateLimit
+	ignored.BandwidthSchedule = newConfig.BandwidthSchedule
+	ignored.CrossZoneUploadRateLimit = newConfig.CrossZoneUploadRateLimit
+	ignored.CrossZoneDownloadRateLimit = newConfig.CrossZoneDownloadRateLimit
+	ignored.BtTrackers = newConfig.BtTrackers
+	ignored.BtAllowedPeers = newConfig.BtAllowedPeers
+	ignored.BtSeederServer = newConfig.BtSeederServer
+	ignored.BtDHTNodes = newConfig.BtDHTNodes
+	ignored.TLSCACert = newConfig.TLSCACert
+	ignored.TLSCert = newConfig.TLSCert
+	ignored.TLSKey = newConfig.TLSKey
+	ignored.MaxActiveTorrents = newConfig.MaxActiveTorrents
+	ignored.MaxRegistryFetches = newConfig.MaxRegistryFetches
+	ignored.LayerOrder = newConfig.LayerOrder
+	if !reflect.DeepEqual(ignored, newConfig) {
+		log.Warnf("Some configuration changes require a restart of the daemon to take effect")
+	}
+
+	daemon.config = &newConfig
+	return limitsChanged
+}
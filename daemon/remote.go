@@ -1,28 +1,139 @@
 package daemon
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
 	"net"
 	"strings"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/go-connections/tlsconfig"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/hustcat/oci-torrent/api/grpc/types"
 )
 
-// TODO: parse flags and pass opts
-func (daemon *Daemon) getRemotePeer(address string) types.APIClient {
+// getRemotePeer returns a client of the GRPC API of another daemon. One
+// connection is kept per address and shared by all the pulls.
+func (daemon *Daemon) getRemotePeer(address string) (types.APIClient, error) {
+	daemon.remoteLock.Lock()
+	defer daemon.remoteLock.Unlock()
+
+	conn, ok := daemon.remotes[address]
+	if !ok {
+		var err error
+		if conn, err = daemon.dialRemotePeer(address); err != nil {
+			return nil, err
+		}
+		daemon.remotes[address] = conn
+	}
+	return types.NewAPIClient(conn), nil
+}
+
+// closeRemotePeers closes the connections to the daemons not in addresses
+func (daemon *Daemon) closeRemotePeers(addresses []string) {
+	keep := map[string]bool{}
+	for _, address := range addresses {
+		keep[address] = true
+	}
+
+	daemon.remoteLock.Lock()
+	defer daemon.remoteLock.Unlock()
+	for address, conn := range daemon.remotes {
+		if keep[address] {
+			continue
+		}
+		if err := conn.Close(); err != nil {
+			log.Warnf("Close connection to %s failed: %v", address, err)
+		}
+		delete(daemon.remotes, address)
+	}
+}
+
+// tlsFingerprint identifies the TLS files of c and their content
+func tlsFingerprint(c *Config) string {
+	h := sha256.New()
+	for _, f := range []string{c.TLSCACert, c.TLSCert, c.TLSKey} {
+		fmt.Fprintf(h, "%d:%s", len(f), f)
+		if data, err := ioutil.ReadFile(f); err == nil {
+			h.Write(data)
+		}
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// reloadRemoteTLS closes all the connections to other daemons when the TLS
+// files of c or their content changed, they are dialed again with the new
+// certificates
+func (daemon *Daemon) reloadRemoteTLS(c *Config) {
+	fingerprint := tlsFingerprint(c)
+
+	daemon.remoteLock.Lock()
+	defer daemon.remoteLock.Unlock()
+	if fingerprint == daemon.remoteTLS {
+		return
+	}
+	daemon.remoteTLS = fingerprint
+	for address, conn := range daemon.remotes {
+		if err := conn.Close(); err != nil {
+			log.Warnf("Close connection to %s failed: %v", address, err)
+		}
+		delete(daemon.remotes, address)
+	}
+	log.Infof("TLS files changed, connections to other daemons use them")
+}
+
+// dialRemotePeer connects to the GRPC API of another daemon, TCP peers are
+// authenticated with mutual TLS
+func (daemon *Daemon) dialRemotePeer(address string) (*grpc.ClientConn, error) {
 	bindParts := strings.SplitN(address, "://", 2)
+	if len(bindParts) != 2 {
+		return nil, fmt.Errorf("bad seeder address format %s, expected proto://address", address)
+	}
 
-	dialOpts := []grpc.DialOption{grpc.WithInsecure(), grpc.WithTimeout(daemon.config.ConnTimeout)}
+	dialOpts := []grpc.DialOption{grpc.WithTimeout(daemon.getConfig().ConnTimeout)}
+	if bindParts[0] == "tcp" {
+		creds, err := daemon.getPeerCredentials(bindParts[1])
+		if err != nil {
+			return nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(creds))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
 	dialOpts = append(dialOpts,
 		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
 			return net.DialTimeout(bindParts[0], bindParts[1], timeout)
 		},
 		))
-	conn, err := grpc.Dial(address, dialOpts...)
+	return grpc.Dial(address, dialOpts...)
+}
+
+// getPeerCredentials returns the mutual TLS credentials used to talk to
+// the TCP seeder at address
+func (daemon *Daemon) getPeerCredentials(address string) (credentials.TransportCredentials, error) {
+	c := daemon.getConfig()
+	if c.TLSCACert == "" || c.TLSCert == "" || c.TLSKey == "" {
+		return nil, fmt.Errorf("TLS CA, certificate and key are required to connect to %s", address)
+	}
+
+	tlsConfig, err := tlsconfig.Client(tlsconfig.Options{
+		CAFile:   c.TLSCACert,
+		CertFile: c.TLSCert,
+		KeyFile:  c.TLSKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, err := net.SplitHostPort(address)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return types.NewAPIClient(conn)
+	tlsConfig.ServerName = host
+	return credentials.NewTLS(tlsConfig), nil
 }
@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestRemotePeers(t *testing.T) {
+	daemon := &Daemon{
+		config:  &Config{ConnTimeout: time.Second},
+		remotes: map[string]*grpc.ClientConn{},
+	}
+	seeders := []string{"unix:///run/seeder-1.sock", "unix:///run/seeder-2.sock"}
+	for i := 0; i < 2; i++ {
+		for _, address := range seeders {
+			if _, err := daemon.getRemotePeer(address); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(daemon.remotes) != 2 {
+		t.Errorf("expected one connection per seeder, got %d", len(daemon.remotes))
+	}
+
+	daemon.closeRemotePeers(seeders[1:])
+	if _, ok := daemon.remotes[seeders[0]]; ok || len(daemon.remotes) != 1 {
+		t.Errorf("expected only the connection to %s, got %v", seeders[1], daemon.remotes)
+	}
+}
+
+func TestReloadRemoteTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config := &Config{
+		ConnTimeout: time.Second,
+		TLSCACert:   path.Join(dir, "ca.pem"),
+		TLSCert:     path.Join(dir, "cert.pem"),
+		TLSKey:      path.Join(dir, "key.pem"),
+	}
+	for _, f := range []string{config.TLSCACert, config.TLSCert, config.TLSKey} {
+		if err := ioutil.WriteFile(f, []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	daemon := &Daemon{
+		config:    config,
+		remotes:   map[string]*grpc.ClientConn{},
+		remoteTLS: tlsFingerprint(config),
+	}
+	if _, err := daemon.getRemotePeer("unix:///run/seeder.sock"); err != nil {
+		t.Fatal(err)
+	}
+
+	daemon.reloadRemoteTLS(config)
+	if len(daemon.remotes) != 1 {
+		t.Errorf("expected the connection kept with the same TLS files")
+	}
+
+	// A certificate rotated in place is used by new connections
+	if err := ioutil.WriteFile(config.TLSCert, []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	daemon.reloadRemoteTLS(config)
+	if len(daemon.remotes) != 0 {
+		t.Errorf("expected the connections closed once the certificate changed, got %v", daemon.remotes)
+	}
+}
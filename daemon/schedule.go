@@ -0,0 +1,208 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// scheduleInterval is how often the bandwidth schedule is evaluated
+const scheduleInterval = 15 * time.Second
+
+// BandwidthWindow sets the global rate limits during a time of day window.
+// Days is a cron day of week field: "*", numbers from 0 (Sunday) to 7
+// (Sunday again) or names from "sun" to "sat", ranges such as "mon-fri" and
+// lists such as "sat,sun". A window whose end is before its start spans
+// midnight and belongs to the day it starts; equal start and end cover the
+// whole day.
+type BandwidthWindow struct {
+	Name     string `json:"name,omitempty"`
+	Days     string `json:"days,omitempty"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Upload   int    `json:"upload"`
+	Download int    `json:"download"`
+}
+
+type bandwidthWindow struct {
+	BandwidthWindow
+	days       [7]bool
+	start, end int // minutes since midnight
+}
+
+var dayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseBandwidthSchedule(windows []BandwidthWindow) ([]*bandwidthWindow, error) {
+	var parsed []*bandwidthWindow
+	for i, w := range windows {
+		pw, err := parseBandwidthWindow(w)
+		if err != nil {
+			return nil, fmt.Errorf("bandwidth window %d: %v", i, err)
+		}
+		parsed = append(parsed, pw)
+	}
+	return parsed, nil
+}
+
+func parseBandwidthWindow(w BandwidthWindow) (*bandwidthWindow, error) {
+	pw := &bandwidthWindow{BandwidthWindow: w}
+	if pw.Name == "" {
+		pw.Name = fmt.Sprintf("%s-%s", w.Start, w.End)
+	}
+	if w.Upload < 0 || w.Download < 0 {
+		return nil, fmt.Errorf("rate limits cannot be negative")
+	}
+
+	var err error
+	if pw.start, err = parseTimeOfDay(w.Start); err != nil {
+		return nil, err
+	}
+	if pw.end, err = parseTimeOfDay(w.End); err != nil {
+		return nil, err
+	}
+
+	days := w.Days
+	if days == "" {
+		days = "*"
+	}
+	for _, field := range strings.Split(days, ",") {
+		if field == "*" {
+			for d := range pw.days {
+				pw.days[d] = true
+			}
+			continue
+		}
+		bounds := strings.SplitN(field, "-", 2)
+		first, err := parseDay(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseDay(bounds[1]); err != nil {
+				return nil, err
+			}
+			// 7 is Sunday, allowed as the end of a range such as "5-7"
+			if last == 0 && bounds[1] == "7" {
+				last = 7
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid day range %q", field)
+		}
+		for d := first; d <= last; d++ {
+			pw.days[d%7] = true
+		}
+	}
+	return pw, nil
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func parseDay(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for i, name := range dayNames {
+		if s == name {
+			return i, nil
+		}
+	}
+	d, err := strconv.Atoi(s)
+	if err != nil || d < 0 || d > 7 {
+		return 0, fmt.Errorf("invalid day %q", s)
+	}
+	return d % 7, nil
+}
+
+// contains returns true if t is inside the window
+func (w *bandwidthWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	yesterday := (day + 6) % 7
+
+	switch {
+	case w.start == w.end:
+		return w.days[day]
+	case w.start < w.end:
+		return w.days[day] && minute >= w.start && minute < w.end
+	default:
+		return (w.days[day] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+	}
+}
+
+// activeWindow returns the first window containing t, nil if none
+func activeWindow(windows []*bandwidthWindow, t time.Time) *bandwidthWindow {
+	for _, w := range windows {
+		if w.contains(t) {
+			return w
+		}
+	}
+	return nil
+}
+
+// runBandwidthSchedule applies the bandwidth schedule until the daemon
+// exits
+func (daemon *Daemon) runBandwidthSchedule() {
+	for {
+		daemon.applyBandwidthSchedule(false)
+		time.Sleep(scheduleInterval)
+	}
+}
+
+// applyBandwidthSchedule sets the global rate limits of the active window,
+// or the configured ones outside of any window. Limits are only set when
+// the active window changes, unless force is true, so that limits set at
+// runtime hold until the next window.
+func (daemon *Daemon) applyBandwidthSchedule(force bool) {
+	if !daemon.btEngine.Started() {
+		return
+	}
+
+	config := daemon.getConfig()
+	windows, err := parseBandwidthSchedule(config.BandwidthSchedule)
+	if err != nil {
+		log.Errorf("Invalid bandwidth schedule: %v", err)
+		return
+	}
+
+	name := ""
+	upload, download := config.UploadRateLimit, config.DownloadRateLimit
+	if w := activeWindow(windows, time.Now()); w != nil {
+		name = w.Name
+		upload, download = w.Upload, w.Download
+	}
+
+	daemon.limitLock.Lock()
+	defer daemon.limitLock.Unlock()
+
+	if !force && name == daemon.bandwidthWindow {
+		return
+	}
+	if err := daemon.btEngine.SetUploadRateLimit(upload); err != nil {
+		log.Errorf("Set upload rate limit failed: %v", err)
+		return
+	}
+	if err := daemon.btEngine.SetDownloadRateLimit(download); err != nil {
+		log.Errorf("Set download rate limit failed: %v", err)
+		return
+	}
+	if name != daemon.bandwidthWindow {
+		log.Infof("Bandwidth window changed from %q to %q, upload %d, download %d", daemon.bandwidthWindow, name, upload, download)
+	}
+	daemon.bandwidthWindow = name
+}
+
+// getBandwidthWindow returns the name of the active bandwidth window
+func (daemon *Daemon) getBandwidthWindow() string {
+	daemon.limitLock.Lock()
+	defer daemon.limitLock.Unlock()
+	return daemon.bandwidthWindow
+}
@@ -0,0 +1,61 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwidthSchedule(t *testing.T) {
+	windows, err := parseBandwidthSchedule([]BandwidthWindow{
+		{Name: "business", Days: "mon-fri", Start: "09:00", End: "18:00", Upload: 1000},
+		{Name: "night", Days: "1-5", Start: "22:00", End: "06:00"},
+		{Name: "weekend", Days: "6,7", Start: "00:00", End: "00:00", Upload: 2000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2017-01-02 is a Monday
+	for _, c := range []struct {
+		time   string
+		window string
+	}{
+		{"2017-01-02 08:59", ""},
+		{"2017-01-02 09:00", "business"},
+		{"2017-01-02 17:59", "business"},
+		{"2017-01-02 18:00", ""},
+		{"2017-01-02 23:00", "night"},
+		{"2017-01-03 05:59", "night"},
+		{"2017-01-02 05:00", ""}, // Sunday night is not in the window
+		{"2017-01-06 10:00", "business"},
+		{"2017-01-07 10:00", "weekend"},
+		{"2017-01-07 03:00", "night"}, // Friday night
+		{"2017-01-08 23:59", "weekend"},
+	} {
+		now, err := time.Parse("2006-01-02 15:04", c.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := ""
+		if w := activeWindow(windows, now); w != nil {
+			name = w.Name
+		}
+		if name != c.window {
+			t.Errorf("%s (%s): expected window %q, got %q", c.time, now.Weekday(), c.window, name)
+		}
+	}
+}
+
+func TestBandwidthScheduleInvalid(t *testing.T) {
+	for _, w := range []BandwidthWindow{
+		{Start: "9:00", End: "25:00"},
+		{Start: "09:00", End: "18:00", Days: "fri-mon"},
+		{Start: "09:00", End: "18:00", Days: "8"},
+		{Start: "09:00", End: "18:00", Days: "workdays"},
+		{Start: "09:00", End: "18:00", Upload: -1},
+	} {
+		if _, err := parseBandwidthWindow(w); err == nil {
+			t.Errorf("expected error for %+v", w)
+		}
+	}
+}
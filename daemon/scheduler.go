@@ -0,0 +1,344 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	imagetypes "github.com/containers/image/types"
+	"golang.org/x/net/context"
+
+	"github.com/hustcat/oci-torrent/api/grpc/types"
+)
+
+// Default number of layers downloaded at once by all the pulls of the
+// daemon, with torrents and from registries
+const (
+	DefaultMaxActiveTorrents  = 8
+	DefaultMaxRegistryFetches = 4
+)
+
+// Slots of the layer scheduler
+const (
+	torrentSlot  = "torrent"
+	registrySlot = "registry"
+)
+
+// Orders of the layers of a pull, smaller layers first or base layers first
+const (
+	LayerOrderSize = "size"
+	LayerOrderBase = "base"
+)
+
+func validateLayerOrder(order string) error {
+	switch order {
+	case "", LayerOrderSize, LayerOrderBase:
+		return nil
+	}
+	return fmt.Errorf("layer-order must be %s or %s, got %q", LayerOrderSize, LayerOrderBase, order)
+}
+
+// layerScheduler bounds the layers downloaded at once by all the pulls of
+// the daemon. The free slots go to the pulls with waiting layers in turn,
+// and to the layer of highest priority within a pull.
+type layerScheduler struct {
+	mu     sync.Mutex
+	limits map[string]int
+	active map[string]int
+	// Pulls with waiting layers by slot, in the order they are served
+	queues map[string][]*layerPull
+}
+
+func newLayerScheduler(torrents, registry int) *layerScheduler {
+	s := &layerScheduler{
+		active: map[string]int{},
+		queues: map[string][]*layerPull{},
+	}
+	s.setLimits(torrents, registry)
+	return s
+}
+
+// setLimits changes the number of torrent and registry slots, layers
+// already downloaded keep their slot
+func (s *layerScheduler) setLimits(torrents, registry int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = map[string]int{
+		torrentSlot:  torrents,
+		registrySlot: registry,
+	}
+	s.dispatch(torrentSlot)
+	s.dispatch(registrySlot)
+}
+
+// dispatch hands out the free slots of kind, s.mu must be held
+func (s *layerScheduler) dispatch(kind string) {
+	for s.active[kind] < s.limits[kind] && len(s.queues[kind]) > 0 {
+		p := s.queues[kind][0]
+		s.queues[kind] = s.queues[kind][1:]
+		w := p.waiting[kind][0]
+		p.waiting[kind] = p.waiting[kind][1:]
+		if len(p.waiting[kind]) > 0 {
+			// Served again after the other pulls
+			s.queues[kind] = append(s.queues[kind], p)
+		}
+		s.active[kind]++
+		close(w.ready)
+	}
+}
+
+// layerPull is a pull of an image scheduling its layers
+type layerPull struct {
+	s     *layerScheduler
+	ranks map[string]int // layer digest -> priority, lowest first
+
+	// Layers waiting for a slot by kind, by priority
+	waiting map[string][]*layerWaiter
+}
+
+type layerWaiter struct {
+	rank  int
+	ready chan struct{}
+}
+
+// newPull schedules the layers of a pull in order: the priority layers in
+// the given order, then the others smaller first or base first
+func (s *layerScheduler) newPull(layers []imagetypes.BlobInfo, order string, priority []string) *layerPull {
+	sorted := make([]imagetypes.BlobInfo, len(layers))
+	copy(sorted, layers)
+	if order != LayerOrderBase {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Size < sorted[j].Size
+		})
+	}
+
+	ranks := map[string]int{}
+	for _, digest := range priority {
+		if _, ok := ranks[digest]; !ok {
+			ranks[digest] = len(ranks)
+		}
+	}
+	for _, l := range sorted {
+		if _, ok := ranks[l.Digest]; !ok {
+			ranks[l.Digest] = len(ranks)
+		}
+	}
+	return &layerPull{
+		s:       s,
+		ranks:   ranks,
+		waiting: map[string][]*layerWaiter{},
+	}
+}
+
+// acquire blocks until a slot of kind is free for the layer with digest,
+// the returned function releases it. It fails once ctx is done.
+func (p *layerPull) acquire(ctx context.Context, kind, digest string) (func(), error) {
+	s := p.s
+	w := &layerWaiter{
+		rank:  p.ranks[digest],
+		ready: make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	waiting := p.waiting[kind]
+	i := sort.Search(len(waiting), func(i int) bool {
+		return waiting[i].rank > w.rank
+	})
+	waiting = append(waiting, nil)
+	copy(waiting[i+1:], waiting[i:])
+	waiting[i] = w
+	p.waiting[kind] = waiting
+	if len(waiting) == 1 {
+		s.queues[kind] = append(s.queues[kind], p)
+	}
+	s.dispatch(kind)
+	s.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			s.mu.Lock()
+			s.active[kind]--
+			s.dispatch(kind)
+			s.mu.Unlock()
+		})
+	}
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	select {
+	case <-w.ready:
+		// Served meanwhile, the slot goes to the next layer
+		s.mu.Unlock()
+		release()
+		return nil, ctx.Err()
+	default:
+	}
+	p.remove(kind, w)
+	s.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+// remove withdraws the waiting layer w, s.mu must be held
+func (p *layerPull) remove(kind string, w *layerWaiter) {
+	s := p.s
+	waiting := p.waiting[kind]
+	for i := range waiting {
+		if waiting[i] == w {
+			p.waiting[kind] = append(waiting[:i:i], waiting[i+1:]...)
+			break
+		}
+	}
+	if len(p.waiting[kind]) > 0 {
+		return
+	}
+	queue := s.queues[kind]
+	for i := range queue {
+		if queue[i] == p {
+			s.queues[kind] = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+}
+
+// newPull schedules layers in the order requested by r, or in the default
+// order of the daemon
+func (daemon *Daemon) newPull(layers []imagetypes.BlobInfo, r *types.StartDownloadRequest) *layerPull {
+	order := r.LayerOrder
+	if order == "" {
+		order = daemon.getConfig().LayerOrder
+	}
+	return daemon.scheduler.newPull(layers, order, r.PriorityLayers)
+}
+
+// eachLayer runs fn for all layers concurrently and returns the error of
+// the first failed layer
+func eachLayer(layers []imagetypes.BlobInfo, fn func(imagetypes.BlobInfo) error) error {
+	errs := make([]error, len(layers))
+	var wg sync.WaitGroup
+	for i, layer := range layers {
+		wg.Add(1)
+		go func(i int, layer imagetypes.BlobInfo) {
+			defer wg.Done()
+			errs[i] = fn(layer)
+		}(i, layer)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// layerProgress returns the writer of the progress bar of each layer and
+// the report to use while layers are downloaded. Several layers downloaded
+// at once get a line each, redrawn in place below the report.
+func layerProgress(layers []imagetypes.BlobInfo, reportWriter io.Writer, writeReport func(f string, a ...interface{})) (func(digest string) io.Writer, func(f string, a ...interface{})) {
+	if !reporting(reportWriter) {
+		return func(string) io.Writer { return nil }, writeReport
+	}
+	if len(layers) < 2 {
+		return func(string) io.Writer { return reportWriter }, writeReport
+	}
+	bars := newLayerBars(layers, writeReport)
+	return bars.writer, bars.report
+}
+
+// layerBars draws the last line written by each layer, one line per layer
+type layerBars struct {
+	write func(f string, a ...interface{})
+
+	mu    sync.Mutex
+	index map[string]int
+	lines []string
+	drawn int
+}
+
+func newLayerBars(layers []imagetypes.BlobInfo, write func(f string, a ...interface{})) *layerBars {
+	b := &layerBars{
+		write: write,
+		index: map[string]int{},
+	}
+	for i, l := range layers {
+		b.index[l.Digest] = i
+		b.lines = append(b.lines, l.Digest+": Waiting")
+	}
+	return b
+}
+
+// writer returns the writer of the line of the layer with digest
+func (b *layerBars) writer(digest string) io.Writer {
+	return &layerBar{b, b.index[digest]}
+}
+
+// report writes a report line above the bars
+func (b *layerBars) report(f string, a ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draw(fmt.Sprintf(f, a...))
+}
+
+// draw moves back to the first bar, writes above and draws the bars again.
+// b.mu must be held.
+func (b *layerBars) draw(above string) {
+	var out bytes.Buffer
+	if b.drawn > 0 {
+		fmt.Fprintf(&out, "\033[%dA", b.drawn)
+	}
+	if above = strings.TrimSuffix(above, "\n"); above != "" {
+		for _, l := range strings.Split(above, "\n") {
+			fmt.Fprintf(&out, "\r%s\033[K\n", l)
+		}
+	}
+	for _, l := range b.lines {
+		fmt.Fprintf(&out, "\r%s\033[K\n", l)
+	}
+	b.drawn = len(b.lines)
+	b.write("%s", out.String())
+}
+
+type layerBar struct {
+	bars *layerBars
+	i    int
+}
+
+// Write keeps the last line of p, progress bars start their lines with \r
+func (w *layerBar) Write(p []byte) (int, error) {
+	lines := strings.FieldsFunc(string(p), func(r rune) bool {
+		return r == '\r' || r == '\n'
+	})
+	w.bars.mu.Lock()
+	defer w.bars.mu.Unlock()
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) != "" {
+			w.bars.lines[w.i] = lines[i]
+			w.bars.draw("")
+			break
+		}
+	}
+	return len(p), nil
+}
+
+// uniqueLayers returns layers without duplicates, in order
+func uniqueLayers(layers []imagetypes.BlobInfo) []imagetypes.BlobInfo {
+	var unique []imagetypes.BlobInfo
+	seen := map[string]bool{}
+	for _, l := range layers {
+		if seen[l.Digest] {
+			continue
+		}
+		seen[l.Digest] = true
+		unique = append(unique, l)
+	}
+	return unique
+}
@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	imagetypes "github.com/containers/image/types"
+	"golang.org/x/net/context"
+)
+
+func TestLayerScheduler(t *testing.T) {
+	s := newLayerScheduler(1, 1)
+	a := s.newPull([]imagetypes.BlobInfo{
+		{Digest: "a1", Size: 30},
+		{Digest: "a2", Size: 10},
+		{Digest: "a3", Size: 20},
+	}, LayerOrderSize, nil)
+	b := s.newPull([]imagetypes.BlobInfo{
+		{Digest: "b1", Size: 10},
+		{Digest: "b2", Size: 20},
+		{Digest: "b3", Size: 30},
+	}, LayerOrderBase, []string{"b3"})
+
+	// Hold the only slot until all the layers wait
+	ctx := context.Background()
+	release, err := a.acquire(ctx, torrentSlot, "a1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	for p, digests := range map[*layerPull][]string{a: {"a1", "a2", "a3"}, b: {"b1", "b2", "b3"}} {
+		for _, d := range digests {
+			wg.Add(1)
+			go func(p *layerPull, d string) {
+				defer wg.Done()
+				done, err := p.acquire(ctx, torrentSlot, d)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				order = append(order, d)
+				mu.Unlock()
+				done()
+			}(p, d)
+		}
+	}
+	for {
+		s.mu.Lock()
+		n := len(a.waiting[torrentSlot]) + len(b.waiting[torrentSlot])
+		s.mu.Unlock()
+		if n == 6 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The registry slots are separate
+	done, err := s.newPull(nil, LayerOrderSize, nil).acquire(ctx, registrySlot, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done()
+
+	// A cancelled layer gives up its place
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.newPull(nil, LayerOrderSize, nil).acquire(cancelled, torrentSlot, "c2"); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// The first pull to wait is served first, then the pulls in turn
+	release()
+	wg.Wait()
+	first := order[0][:1]
+	var expected []string
+	for _, pair := range [][]string{{"a2", "b3"}, {"a3", "b1"}, {"a1", "b2"}} {
+		if first == "b" {
+			pair[0], pair[1] = pair[1], pair[0]
+		}
+		expected = append(expected, pair...)
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected order %v, got %v", expected, order)
+	}
+}
+
+func TestLayerProgress(t *testing.T) {
+	var out bytes.Buffer
+	writeReport := func(f string, a ...interface{}) {
+		fmt.Fprintf(&out, f, a...)
+	}
+	layers := []imagetypes.BlobInfo{{Digest: "a"}, {Digest: "b"}}
+
+	// A single layer writes its bar as is
+	bars, report := layerProgress(layers[:1], &out, writeReport)
+	fmt.Fprint(bars("a"), "\rbar a")
+	report("done\n")
+	if got := out.String(); got != "\rbar adone\n" {
+		t.Errorf("expected the bar and report as is, got %q", got)
+	}
+
+	out.Reset()
+	bars, report = layerProgress(layers, &out, writeReport)
+	fmt.Fprint(bars("b"), "\rbar b")
+	report("done\n")
+	fmt.Fprint(bars("a"), "\rbar a\n")
+	screen := strings.Split(out.String(), "\033[2A")
+	expected := []string{
+		"\ra: Waiting\033[K\n\rbar b\033[K\n",
+		"\rdone\033[K\n\ra: Waiting\033[K\n\rbar b\033[K\n",
+		"\rbar a\033[K\n\rbar b\033[K\n",
+	}
+	if !reflect.DeepEqual(screen, expected) {
+		t.Errorf("expected %q, got %q", expected, screen)
+	}
+
+	// Nothing is drawn for a pull in flight without a caller reading it
+	bars, _ = layerProgress(layers, &flightWriter{writers: map[*io.Writer]bool{}}, writeReport)
+	if bars("a") != nil {
+		t.Errorf("expected no progress bar without reader")
+	}
+}
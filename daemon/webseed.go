@@ -0,0 +1,90 @@
+package daemon
+
+import (
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/hustcat/oci-torrent/bt"
+)
+
+// WebSeedHandler serves the complete layer files with HTTP range requests,
+// for the leechers using this daemon as a web seed (BEP 19)
+func (daemon *Daemon) WebSeedHandler() http.Handler {
+	return http.StripPrefix(bt.WebSeedPath, http.HandlerFunc(daemon.serveLayer))
+}
+
+func (daemon *Daemon) serveLayer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimSuffix(r.URL.Path, ".layer")
+	if b, err := hex.DecodeString(id); err != nil || len(b) != 32 || r.URL.Path != id+".layer" {
+		http.NotFound(w, r)
+		return
+	}
+	if !daemon.webSeedAllowed(r.RemoteAddr) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Only serve the layers whose download is complete
+	s, err := daemon.btEngine.GetStatus(id)
+	if err != nil || s.TotalLen == 0 || s.Completed < s.TotalLen {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(daemon.btEngine.GetFilePath(id))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Debugf("Serve layer %s to web seed client %s, range %q", id, r.RemoteAddr, r.Header.Get("Range"))
+	http.ServeContent(&countingResponseWriter{ResponseWriter: w}, r, "", fi.ModTime(), f)
+}
+
+// webSeedAllowed returns whether the client at addr is in the subnets
+// peers are allowed from. Without subnets, only the layers of swarms that
+// are neither private nor authenticated are served.
+func (daemon *Daemon) webSeedAllowed(addr string) bool {
+	c := daemon.getConfig()
+	subnets := c.BtAllowedPeers
+	if len(subnets) == 0 {
+		return !c.BtPrivate && c.BtPeerSecretFile == ""
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	allowed, err := bt.ParseAllowedPeers(subnets)
+	if ip == nil || err != nil {
+		return false
+	}
+	_, blocked := allowed.Lookup(ip)
+	return !blocked
+}
+
+// countingResponseWriter adds the bytes written to the served bytes metric
+type countingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	webSeedServedBytes.Add(float64(n))
+	return n, err
+}
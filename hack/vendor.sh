@@ -58,6 +58,13 @@ clone git github.com/google/btree 7d79101e329e5a3adf994758c578dab82b90c017
 clone git github.com/ryszard/goskiplist 2dfbae5fcf46374f166f8969cb07e167f1be6273
 clone git github.com/willf/bitset 2e6e8094ef4745224150c88c16191c7dceaad16f
 
+# Changes to the vendored packages, applied in order. A refresh keeps them
+# only if they still apply, hack/validate-vendor-patches checks them.
+for patch in hack/patches/*.patch; do
+	echo "apply $patch"
+	git apply -p2 --directory=vendor/src "$patch"
+done
+
 clean
 
 mv vendor/src/* vendor/
@@ -0,0 +1,386 @@
+// Package metrics implements the counters, gauges and histograms exported
+// by the daemon in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets, in seconds
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+// Registry holds a set of metrics and the hooks refreshing them before
+// each collection
+type Registry struct {
+	mu       sync.Mutex
+	metrics  []*metric
+	names    map[string]bool
+	collects []func()
+
+	// Held while collecting and writing, so that a collection never sees
+	// the metrics half refreshed by another
+	collectMu sync.Mutex
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		names: map[string]bool{},
+	}
+}
+
+// DefaultRegistry is the registry used by the package level functions
+var DefaultRegistry = NewRegistry()
+
+type series struct {
+	labels []string
+	value  float64
+
+	// histogram only
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type metric struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+
+	// set for the metrics read from elsewhere on each collection
+	collect Collector
+}
+
+// Collector emits the series of a metric maintained elsewhere, calling
+// emit with the value and the label values of each
+type Collector func(emit func(v float64, values ...string))
+
+func (r *Registry) register(name, help string, typ metricType, buckets []float64, labels []string, collect Collector) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %s", name))
+	}
+	r.names[name] = true
+	m := &metric{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+		collect: collect,
+	}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// OnCollect registers f to be called before each collection, usually to
+// refresh gauges computed from the state of the daemon
+func (r *Registry) OnCollect(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collects = append(r.collects, f)
+}
+
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		if m.typ == histogramType {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+func (m *metric) add(values []string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(values).value += v
+}
+
+func (m *metric) set(values []string, v float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(values).value = v
+}
+
+func (m *metric) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.series = map[string]*series{}
+}
+
+// CounterVec is a counter partitioned by label values
+type CounterVec struct {
+	m *metric
+}
+
+// NewCounter registers a counter with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, counterType, nil, labels, nil)}
+}
+
+// NewCounterFunc registers a counter read from collect on each collection,
+// for counters maintained elsewhere. The values emitted must never decrease.
+func (r *Registry) NewCounterFunc(name, help string, collect Collector, labels ...string) {
+	r.register(name, help, counterType, nil, labels, collect)
+}
+
+// Inc adds one to the counter of values
+func (c *CounterVec) Inc(values ...string) {
+	c.m.add(values, 1)
+}
+
+// Add adds v, which must not be negative, to the counter of values
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.m.name))
+	}
+	c.m.add(values, v)
+}
+
+// GaugeVec is a gauge partitioned by label values
+type GaugeVec struct {
+	m *metric
+}
+
+// NewGauge registers a gauge with the given label names
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, gaugeType, nil, labels, nil)}
+}
+
+// NewGaugeFunc registers a gauge read from collect on each collection
+func (r *Registry) NewGaugeFunc(name, help string, collect Collector, labels ...string) {
+	r.register(name, help, gaugeType, nil, labels, collect)
+}
+
+// Set sets the gauge of values
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.m.set(values, v)
+}
+
+// Add adds v to the gauge of values
+func (g *GaugeVec) Add(v float64, values ...string) {
+	g.m.add(values, v)
+}
+
+// Reset removes all the series of the gauge
+func (g *GaugeVec) Reset() {
+	g.m.reset()
+}
+
+// HistogramVec is a histogram partitioned by label values
+type HistogramVec struct {
+	m *metric
+}
+
+// NewHistogram registers a histogram with the given upper bounds of the
+// buckets, sorted in increasing order, and label names
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not sorted", name))
+	}
+	return &HistogramVec{r.register(name, help, histogramType, buckets, labels, nil)}
+}
+
+// Observe adds v to the histogram of values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	m := h.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.get(values)
+	for i, b := range m.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+// WriteTo writes all the metrics of the registry to w in the Prometheus
+// text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collects := append([]func(){}, r.collects...)
+	metrics := append([]*metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	r.collectMu.Lock()
+	defer r.collectMu.Unlock()
+	for _, f := range collects {
+		f()
+	}
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// Handler returns an HTTP handler serving the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+func (m *metric) write(w *countWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.collect != nil {
+		m.series = map[string]*series{}
+		m.collect(func(v float64, values ...string) {
+			m.get(values).value = v
+		})
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.typ != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatValue(s.value))
+			continue
+		}
+		for i, b := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", formatValue(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatLabels(m.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatLabels(m.labels, s.labels, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatLabels(m.labels, s.labels, "", ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var pairs []string
+	for i, n := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", n, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	return n, err
+}
+
+// NewCounter registers a counter in the default registry
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewCounterFunc registers a counter read from collect in the default
+// registry
+func NewCounterFunc(name, help string, collect Collector, labels ...string) {
+	DefaultRegistry.NewCounterFunc(name, help, collect, labels...)
+}
+
+// NewGauge registers a gauge in the default registry
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGauge(name, help, labels...)
+}
+
+// NewGaugeFunc registers a gauge read from collect in the default registry
+func NewGaugeFunc(name, help string, collect Collector, labels ...string) {
+	DefaultRegistry.NewGaugeFunc(name, help, collect, labels...)
+}
+
+// NewHistogram registers a histogram in the default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogram(name, help, buckets, labels...)
+}
+
+// OnCollect registers a collection hook in the default registry
+func OnCollect(f func()) {
+	DefaultRegistry.OnCollect(f)
+}
+
+// Handler returns an HTTP handler serving the default registry
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
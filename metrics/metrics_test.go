@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_requests_total", "Requests.\nAll of them.", "method")
+	g := r.NewGauge("test_peers", "Peers.")
+	h := r.NewHistogram("test_duration_seconds", "Durations.", []float64{1, 5}, "phase")
+
+	collected := false
+	r.OnCollect(func() {
+		collected = true
+		g.Set(3)
+	})
+
+	c.Inc("Status")
+	c.Add(2, `Get"Torrent`)
+	h.Observe(0.5, "download")
+	h.Observe(2, "download")
+	h.Observe(10, "download")
+
+	var b bytes.Buffer
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !collected {
+		t.Fatal("collect hook not called")
+	}
+
+	expected := `# HELP test_requests_total Requests.\nAll of them.
+# TYPE test_requests_total counter
+test_requests_total{method="Get\"Torrent"} 2
+test_requests_total{method="Status"} 1
+# HELP test_peers Peers.
+# TYPE test_peers gauge
+test_peers 3
+# HELP test_duration_seconds Durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{phase="download",le="1"} 1
+test_duration_seconds_bucket{phase="download",le="5"} 2
+test_duration_seconds_bucket{phase="download",le="+Inf"} 3
+test_duration_seconds_sum{phase="download"} 12.5
+test_duration_seconds_count{phase="download"} 3
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+
+}
+
+func TestFuncMetrics(t *testing.T) {
+	r := NewRegistry()
+	uploaded := map[string]float64{"layer": 10}
+	r.NewCounterFunc("test_uploaded_bytes_total", "Uploaded bytes.", func(emit func(float64, ...string)) {
+		for id, v := range uploaded {
+			emit(v, id)
+		}
+	}, "id")
+	r.NewGaugeFunc("test_torrents", "Torrents.", func(emit func(float64, ...string)) {
+		emit(float64(len(uploaded)))
+	})
+
+	var b bytes.Buffer
+	r.WriteTo(&b)
+	expected := `# HELP test_uploaded_bytes_total Uploaded bytes.
+# TYPE test_uploaded_bytes_total counter
+test_uploaded_bytes_total{id="layer"} 10
+# HELP test_torrents Torrents.
+# TYPE test_torrents gauge
+test_torrents 1
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected output:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+
+	// The series follow the values read on each collection
+	uploaded["layer"] = 25
+	uploaded["other"] = 1
+	b.Reset()
+	r.WriteTo(&b)
+	for _, line := range []string{`test_uploaded_bytes_total{id="layer"} 25`, `test_uploaded_bytes_total{id="other"} 1`, "test_torrents 2"} {
+		if !bytes.Contains(b.Bytes(), []byte(line)) {
+			t.Fatalf("expected %s in:\n%s", line, b.String())
+		}
+	}
+	delete(uploaded, "other")
+	b.Reset()
+	r.WriteTo(&b)
+	if bytes.Contains(b.Bytes(), []byte(`id="other"`)) {
+		t.Fatalf("series of a removed value still collected:\n%s", b.String())
+	}
+}
+
+func TestWriteToConcurrent(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGauge("test_torrent_peers", "Peers.", "id")
+	// Slow collections overlap unless serialized
+	r.OnCollect(func() {
+		g.Reset()
+		time.Sleep(time.Millisecond)
+		g.Set(1, "layer")
+	})
+	r.OnCollect(func() {
+		time.Sleep(time.Millisecond)
+	})
+
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			for j := 0; j < 20; j++ {
+				var b bytes.Buffer
+				r.WriteTo(&b)
+				if !bytes.Contains(b.Bytes(), []byte(`test_torrent_peers{id="layer"} 1`)) {
+					errs <- fmt.Errorf("series missing from a concurrent collection:\n%s", b.String())
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -83,7 +83,8 @@ func (e dirLayout) initialize() error {
 
 // PutBlob adds a new blob to the image. This is idempotent; a nil error
 // means that "the content is stored at DIGEST" without implying "because
-// of this PutBlob() call".
+// of this PutBlob() call". The copy stops once ctx is done, and the
+// half-written blob is removed.
 func (e dirLayout) PutBlob(ctx context.Context, reader io.Reader) (string, int64, error) {
 	hash := sha256.New()
 	algo := BlobAlgorithm
@@ -96,9 +97,15 @@ func (e dirLayout) PutBlob(ctx context.Context, reader io.Reader) (string, int64
 	}
 	tempPath := fh.Name()
 	defer fh.Close()
+	stored := false
+	defer func() {
+		if !stored {
+			os.Remove(tempPath)
+		}
+	}()
 
 	writer := io.MultiWriter(fh, hash)
-	size, err := io.Copy(writer, reader)
+	size, err := io.Copy(writer, contextReader{ctx, reader})
 	if err != nil {
 		return "", -1, err
 	}
@@ -116,10 +123,24 @@ func (e dirLayout) PutBlob(ctx context.Context, reader io.Reader) (string, int64
 	if err := os.Rename(tempPath, path); err != nil {
 		return "", -1, err
 	}
+	stored = true
 
 	return digest, int64(size), nil
 }
 
+// contextReader fails once ctx is done
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
+
 // PutReference adds a new reference descriptor blob to the image. This is
 // idempotent; a nil error means that "the descriptor is stored at NAME"
 // without implying "because of this PutReference() call". ErrClobber is
@@ -305,6 +326,73 @@ func (e dirLayout) GetBlobPath(ctx context.Context, digest string) (path string,
 	return filepath.Join(e.path, path), nil
 }
 
+func (e dirLayout) GetBlobTempPath(ctx context.Context, digest string) (path string, err error) {
+	path, err = blobPath(digest)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(e.path, partialDirectory, "blob-"+filepath.Base(path)), nil
+}
+
+// PutSignatures replaces the signatures stored for the reference NAME.
+// Passing an empty list removes all of them.
+func (e dirLayout) PutSignatures(ctx context.Context, name string, signatures [][]byte) error {
+	path, err := signaturePath(name)
+	if err != nil {
+		return err
+	}
+	path = filepath.Join(e.path, path)
+
+	// Write the new signatures into a temporary directory first to avoid
+	// leaving a half-written set behind.
+	tempDir, err := ioutil.TempDir(e.temp, "sig."+name+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	for i, sig := range signatures {
+		fn := filepath.Join(tempDir, fmt.Sprintf("signature-%d", i+1))
+		if err := ioutil.WriteFile(fn, sig, 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	if len(signatures) == 0 {
+		return nil
+	}
+	return os.Rename(tempDir, path)
+}
+
+// GetSignatures returns the signatures stored for the reference NAME.
+// An empty list is returned if there are none.
+func (e dirLayout) GetSignatures(ctx context.Context, name string) ([][]byte, error) {
+	path, err := signaturePath(name)
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.Join(e.path, path)
+
+	signatures := [][]byte{}
+	for i := 1; ; i++ {
+		sig, err := ioutil.ReadFile(filepath.Join(path, fmt.Sprintf("signature-%d", i)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return nil, err
+		}
+		signatures = append(signatures, sig)
+	}
+	return signatures, nil
+}
+
 func newDirLayout(path string) (*dirLayout, error) {
 	layout := &dirLayout{
 		path: path,
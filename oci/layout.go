@@ -22,6 +22,14 @@ const (
 	// blobDirectory is the directory inside an OCI image that contains blobs.
 	blobDirectory = "blobs"
 
+	// signatureDirectory is the directory inside an OCI image that contains
+	// the signatures of references.
+	signatureDirectory = "signatures"
+
+	// partialDirectory is the directory inside an OCI image that contains
+	// the blobs being downloaded.
+	partialDirectory = "partial"
+
 	// layoutFile is the file in side an OCI image the indicates what version
 	// of the OCI spec the image is.
 	layoutFile = "oci-layout"
@@ -90,6 +98,20 @@ type Layout interface {
 
 	// GetBlobPath returns a path of a blob from the image
 	GetBlobPath(ctx context.Context, digest string) (path string, err error)
+
+	// GetBlobTempPath returns a temporary path for a blob being written, on
+	// the file system of the image so that it can be renamed to the blob
+	// path. The path is the same for every handle of the image and is kept
+	// by Close(), the writer renames or removes it.
+	GetBlobTempPath(ctx context.Context, digest string) (path string, err error)
+
+	// PutSignatures replaces the signatures stored for the reference NAME.
+	// Passing an empty list removes all of them.
+	PutSignatures(ctx context.Context, name string, signatures [][]byte) (err error)
+
+	// GetSignatures returns the signatures stored for the reference NAME.
+	// An empty list is returned if there are none.
+	GetSignatures(ctx context.Context, name string) (signatures [][]byte, err error)
 }
 
 // Open will create an Layout reference to the OCI image at the provided
@@ -122,3 +144,9 @@ func blobPath(digest string) (string, error) {
 func refPath(name string) (string, error) {
 	return filepath.Join(refDirectory, name), nil
 }
+
+// signaturePath returns the path to the directory holding the signatures of
+// a reference given its name, relative to the root of the OCI image.
+func signaturePath(name string) (string, error) {
+	return filepath.Join(signatureDirectory, name), nil
+}
@@ -128,6 +128,33 @@ func TestBlob(t *testing.T) {
 	}
 }
 
+func TestPutBlobCancel(t *testing.T) {
+	root, err := ioutil.TempDir("", "oci-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	layout, err := Open(filepath.Join(root, "busybox"))
+	if err != nil {
+		t.Fatalf("unexpected error opening image: %s", err)
+	}
+	defer layout.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := layout.PutBlob(ctx, bytes.NewReader([]byte("some blob"))); err != context.Canceled {
+		t.Errorf("PutBlob: expected %v, got %v", context.Canceled, err)
+	}
+
+	// The half-written blob is removed
+	if files, err := ioutil.ReadDir(layout.(*dirLayout).temp); err != nil {
+		t.Fatal(err)
+	} else if len(files) > 0 {
+		t.Errorf("got temporary files after a cancelled PutBlob: %v", files[0].Name())
+	}
+}
+
 func TestReference(t *testing.T) {
 	ctx := context.Background()
 
@@ -184,3 +211,50 @@ func TestReference(t *testing.T) {
 		}
 	}
 }
+
+func TestSignatures(t *testing.T) {
+	ctx := context.Background()
+
+	root, err := ioutil.TempDir("", "oci-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	image := filepath.Join(root, "busybox")
+
+	layout, err := Open(image)
+	if err != nil {
+		t.Fatalf("unexpected error opening image: %s", err)
+	}
+	defer layout.Close()
+
+	// No signatures stored yet.
+	if sigs, err := layout.GetSignatures(ctx, "latest"); err != nil {
+		t.Errorf("GetSignatures: unexpected error: %s", err)
+	} else if len(sigs) > 0 {
+		t.Errorf("GetSignatures: got signatures in a newly created image: %v", sigs)
+	}
+
+	for _, test := range []struct {
+		signatures [][]byte
+	}{
+		{[][]byte{[]byte("sig1")}},
+		{[][]byte{[]byte("sig1"), []byte("sig2"), []byte("sig3")}},
+		{[][]byte{[]byte("replaced")}},
+		{[][]byte{}},
+	} {
+		if err := layout.PutSignatures(ctx, "latest", test.signatures); err != nil {
+			t.Errorf("PutSignatures: unexpected error: %s", err)
+		}
+
+		gotSignatures, err := layout.GetSignatures(ctx, "latest")
+		if err != nil {
+			t.Errorf("GetSignatures: unexpected error: %s", err)
+		}
+
+		if !reflect.DeepEqual(test.signatures, gotSignatures) {
+			t.Errorf("GetSignatures: got different signatures to original: expected=%q got=%q", test.signatures, gotSignatures)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// CopyMode is the strategy used to copy layers between the OCI directory
+// and the bt engine
+type CopyMode string
+
+const (
+	// CopyAuto tries a reflink, then a hard link, then a copy
+	CopyAuto CopyMode = "auto"
+	// CopyReflink shares the extents of the file on btrfs and XFS
+	CopyReflink CopyMode = "reflink"
+	// CopyHardlink links the file, both paths must be on the same file
+	// system
+	CopyHardlink CopyMode = "hardlink"
+	// CopyStream reads and writes the whole file
+	CopyStream CopyMode = "copy"
+)
+
+// ParseCopyMode returns the copy mode named s
+func ParseCopyMode(s string) (CopyMode, error) {
+	switch m := CopyMode(s); m {
+	case CopyAuto, CopyReflink, CopyHardlink, CopyStream:
+		return m, nil
+	}
+	return "", fmt.Errorf("copy mode must be %s, %s, %s or %s, got %q", CopyAuto, CopyReflink, CopyHardlink, CopyStream, s)
+}
+
+// strategies returns the strategies tried in order for mode, a copy always
+// comes last
+func (mode CopyMode) strategies() []CopyMode {
+	switch mode {
+	case CopyAuto:
+		return []CopyMode{CopyReflink, CopyHardlink, CopyStream}
+	case CopyReflink, CopyHardlink:
+		return []CopyMode{mode, CopyStream}
+	}
+	return []CopyMode{CopyStream}
+}
+
+// CopyFile copies src to dst with the first strategy of mode that works and
+// returns it. dst is replaced atomically if it exists, and left alone if it
+// is already a hard link of src.
+func CopyFile(src, dst string, mode CopyMode) (CopyMode, error) {
+	si, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	if di, err := os.Stat(dst); err == nil && os.SameFile(si, di) {
+		return CopyHardlink, nil
+	}
+
+	var errs []string
+	for _, s := range mode.strategies() {
+		err = copyWith(s, src, dst)
+		if err == nil {
+			return s, nil
+		}
+		log.Debugf("Copy %s to %s with %s failed: %v", src, dst, s, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", s, err))
+	}
+	return "", fmt.Errorf("Copy %s to %s failed: %v", src, dst, errs)
+}
+
+// copyMode is the mode of the copies, readable by all as the files created
+// with the default umask
+const copyMode = 0644
+
+// copyWith copies src to a temporary file next to dst with strategy s, and
+// renames it to dst
+func copyWith(s CopyMode, src, dst string) error {
+	f, err := ioutil.TempFile(filepath.Dir(dst), ".copy-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	switch s {
+	case CopyHardlink:
+		f.Close()
+		if err = os.Remove(tmp); err != nil {
+			return err
+		}
+		err = os.Link(src, tmp)
+	case CopyReflink:
+		err = withSource(src, func(r *os.File) error {
+			return reflink(f, r)
+		})
+		if err == nil {
+			err = f.Chmod(copyMode)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	default:
+		err = withSource(src, func(r *os.File) error {
+			_, err := io.Copy(f, r)
+			return err
+		})
+		if err == nil {
+			err = f.Chmod(copyMode)
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func withSource(src string, fn func(*os.File) error) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return fn(r)
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "copy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	content := bytes.Repeat([]byte("layer"), 1000)
+	src := filepath.Join(root, "src")
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []CopyMode{CopyAuto, CopyReflink, CopyHardlink, CopyStream} {
+		dst := filepath.Join(root, string(mode))
+		// An existing destination is replaced
+		if err := ioutil.WriteFile(dst, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			used, err := CopyFile(src, dst, mode)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", mode, err)
+			}
+			if mode == CopyStream && used != CopyStream {
+				t.Errorf("%s: expected a copy, got %s", mode, used)
+			}
+			if data, err := ioutil.ReadFile(dst); err != nil || !bytes.Equal(data, content) {
+				t.Errorf("%s: unexpected content, error %v", mode, err)
+			}
+			// Copies are readable by all, not private temporary files
+			if fi, err := os.Stat(dst); err != nil {
+				t.Errorf("%s: unexpected error: %s", mode, err)
+			} else if fi.Mode().Perm() != 0644 {
+				t.Errorf("%s: expected mode 0644, got %v", mode, fi.Mode())
+			}
+		}
+	}
+
+	if _, err := CopyFile(filepath.Join(root, "missing"), filepath.Join(root, "dst"), CopyAuto); err == nil {
+		t.Errorf("expected error for missing source")
+	}
+	if _, err := ParseCopyMode("symlink"); err == nil {
+		t.Errorf("expected error for unknown mode")
+	}
+	if m, err := ParseCopyMode("reflink"); err != nil || m != CopyReflink {
+		t.Errorf("unexpected mode %q, error %v", m, err)
+	}
+}
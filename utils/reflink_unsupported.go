@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+import (
+	"fmt"
+	"os"
+)
+
+func reflink(dst, src *os.File) error {
+	return fmt.Errorf("reflinks are only supported on linux")
+}
@@ -9,6 +9,8 @@ import (
 func NewProgressBar(total int, w io.Writer) *pb.ProgressBar {
 	bar := pb.New(total).SetUnits(pb.U_BYTES)
 	bar.Output = w
+	// Finish writes to w only
+	bar.NotPrint = true
 	bar.SetMaxWidth(80)
 	bar.ShowTimeLeft = false
 	bar.ShowPercent = false